@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/notnil/chess"
+)
+
+// Ply is the assessment of a single move in an analysed game.
+type Ply struct {
+	Position *chess.Position
+	Move     *chess.Move
+	SAN      string
+	// engine's preferred move in algebraic notation, empty if it couldn't be
+	// decoded, and the line it expected to follow
+	BestMove string
+	BestLine []string
+
+	// analysis of the positions before and after the move
+	Before Result
+	After  Result
+
+	// expected points given away by the player making the move
+	Lost  float64
+	Class Classification
+	Phase Phase
+	// tactics in the engine's line that the move missed, and in the
+	// opponent's best reply that it allowed, for inaccuracies and worse
+	Missed   []Motif
+	Conceded []Motif
+
+	// time left on the clock after the move and time spent on it, if the
+	// game was timed
+	Timed bool
+	Clock time.Duration
+	Spent time.Duration
+}
+
+// assessMoves classifies every move in g given the analysis of each of its
+// positions.
+func assessMoves(g *chess.Game, results []Result) []Ply {
+	nalg := chess.AlgebraicNotation{}
+
+	positions := g.Positions()
+	phaseOf := gamePhases(positions)
+	plies := make([]Ply, len(g.Moves()))
+	for i, gameMove := range g.Moves() {
+		p := Ply{
+			Position: positions[i],
+			Move:     gameMove,
+			SAN:      nalg.Encode(positions[i], gameMove),
+			Before:   results[i],
+			After:    results[i+1],
+			Phase:    phaseOf[i],
+		}
+
+		// decoded as a legal move so it has check tags, as the move played does
+		if bestMove, err := decodeMove(positions[i], p.Before.BestMove); err != nil {
+			if p.Before.hasEval() {
+				log.WithError(err).WithField("move", p.Before.BestMove).
+					Warn("Could not decode best move")
+			}
+		} else {
+			p.BestMove = nalg.Encode(positions[i], bestMove)
+		}
+		p.BestLine = sanLine(positions[i], p.Before.PV)
+
+		if p.Before.Err != nil || p.After.Err != nil {
+			p.Class = Unclassified
+			plies[i] = p
+			continue
+		}
+
+		// expected scores are from white's perspective
+		p.Lost = p.Before.ExpectedScore() - p.After.ExpectedScore()
+		if positions[i].Turn() == chess.Black {
+			p.Lost = 0 - p.Lost
+		}
+		p.Class = ClassifyMove(p.BestMove != "" && p.SAN == p.BestMove, p.Lost)
+		if p.Class >= Inaccuracy {
+			p.Missed, p.Conceded = flaggedMotifs(positions[i], p.Before.PV, positions[i+1], p.After.PV)
+		}
+
+		plies[i] = p
+	}
+
+	return plies
+}
+
+// moveNumber returns the number of the move about to be played in pos in
+// PGN format, e.g. "3." for white's third move and "3..." for black's.
+func moveNumber(pos *chess.Position) string {
+	n := "1"
+	if fields := strings.Fields(pos.String()); len(fields) == 6 {
+		n = fields[5]
+	}
+
+	if pos.Turn() == chess.Black {
+		return n + "..."
+	}
+	return n + "."
+}
+
+// sanLine converts a line of UCI moves played from pos to algebraic
+// notation, stopping at the first move that can't be decoded.
+func sanLine(pos *chess.Position, uci []string) []string {
+	nalg := chess.AlgebraicNotation{}
+	var line []string
+	for _, s := range uci {
+		m, err := decodeMove(pos, s)
+		if err != nil {
+			break
+		}
+		line = append(line, nalg.Encode(pos, m))
+		pos = pos.Update(m)
+	}
+	return line
+}
+
+// variationString formats a line of SAN moves played from pos with move
+// numbers, e.g. "12...Qd5 13.Nf3".
+func variationString(pos *chess.Position, line []string) string {
+	buf := &strings.Builder{}
+	n, _ := strconv.Atoi(strings.TrimRight(moveNumber(pos), "."))
+	black := pos.Turn() == chess.Black
+	for i, san := range line {
+		if i > 0 {
+			buf.WriteString(" ")
+		}
+		switch {
+		case i == 0 && black:
+			fmt.Fprintf(buf, "%d...", n)
+		case !black:
+			fmt.Fprintf(buf, "%d.", n)
+		}
+		buf.WriteString(san)
+
+		if black {
+			n++
+		}
+		black = !black
+	}
+	return buf.String()
+}
+
+// eval formats the result for a [%eval] comment, from white's perspective.
+func (r Result) eval() string {
+	if r.Mate != 0 {
+		return fmt.Sprintf("#%d", r.Mate)
+	}
+	// avoid printing -0.00
+	if math.Abs(r.Score) < 0.005 {
+		return "0.00"
+	}
+	return fmt.Sprintf("%.2f", r.Score)
+}
+
+// hasEval returns whether the engine searched the position, i.e. it didn't
+// fail and the game wasn't already over.
+func (r Result) hasEval() bool {
+	return r.Err == nil && r.BestMove != "" && r.BestMove != "(none)"
+}
+
+// annotatedPGN formats an analysed game in PGN export format. The original
+// tags are kept, evaluations are added as [%eval] comments, and the engine's
+// preferred move is given as a variation for inaccuracies and worse.
+func annotatedPGN(g *chess.Game, plies []Ply, summaries [2]Summary, engine string) string {
+	buf := &strings.Builder{}
+	result := string(g.Outcome())
+	for _, t := range pgnTags(g, summaries, engine) {
+		if t.Key == "Result" {
+			result = t.Value
+		}
+		fmt.Fprintf(buf, "[%s \"%s\"]\n", t.Key, escapeTagValue(t.Value))
+	}
+	buf.WriteString("\n")
+
+	w := &movetextWriter{buf: buf}
+	for _, p := range plies {
+		w.move(p.Position, p.SAN+p.Class.NAG())
+
+		var commands []string
+		if p.After.hasEval() {
+			commands = append(commands, fmt.Sprintf("[%%eval %s]", p.After.eval()))
+		}
+		if p.Timed {
+			commands = append(commands,
+				fmt.Sprintf("[%%clk %s]", formatClock(p.Clock)),
+				fmt.Sprintf("[%%emt %s]", formatClock(p.Spent)))
+		}
+		if len(commands) > 0 {
+			w.comment(strings.Join(commands, " "))
+		}
+
+		if p.Class >= Inaccuracy && p.Before.hasEval() && p.BestMove != "" {
+			w.variation(fmt.Sprintf("%s %s { [%%eval %s] }",
+				moveNumber(p.Position), p.BestMove, p.Before.eval()))
+		}
+	}
+	w.token(result)
+	buf.WriteString("\n")
+
+	return buf.String()
+}
+
+// sevenTagRoster are the tags every PGN game must have, in order.
+var sevenTagRoster = []string{"Event", "Site", "Date", "Round", "White", "Black", "Result"}
+
+// pgnTags returns the Seven Tag Roster followed by the game's other tags and
+// the summary of the analysis.
+func pgnTags(g *chess.Game, summaries [2]Summary, engine string) []chess.TagPair {
+	var tags []chess.TagPair
+	for _, k := range sevenTagRoster {
+		t := chess.TagPair{Key: k, Value: "?"}
+		if k == "Result" {
+			t.Value = string(g.Outcome())
+		}
+		if v := g.GetTagPair(k); v != nil {
+			t.Value = v.Value
+		}
+		tags = append(tags, t)
+	}
+
+	for _, t := range g.TagPairs() {
+		var isRoster bool
+		for _, k := range sevenTagRoster {
+			isRoster = isRoster || t.Key == k
+		}
+		if !isRoster {
+			tags = append(tags, *t)
+		}
+	}
+
+	tags = append(tags, chess.TagPair{Key: "Annotator", Value: engine})
+	for _, s := range summaries {
+		c := colorName(s.Color)
+		tags = append(tags,
+			chess.TagPair{Key: c + "Accuracy", Value: fmt.Sprintf("%.1f", s.Accuracy)},
+			chess.TagPair{Key: c + "ACPL", Value: fmt.Sprintf("%.0f", s.ACPL)},
+			chess.TagPair{Key: c + "Inaccuracies", Value: fmt.Sprint(s.Inaccuracies)},
+			chess.TagPair{Key: c + "Mistakes", Value: fmt.Sprint(s.Mistakes)},
+			chess.TagPair{Key: c + "Blunders", Value: fmt.Sprint(s.Blunders)},
+		)
+	}
+
+	return tags
+}
+
+func escapeTagValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	return strings.ReplaceAll(v, `"`, `\"`)
+}
+
+// movetextWriter lays out PGN movetext, wrapping lines before they exceed
+// 80 characters and only numbering black's moves where required.
+type movetextWriter struct {
+	buf     *strings.Builder
+	lineLen int
+	// whether a comment or variation came after the last move
+	interrupted bool
+}
+
+func (w *movetextWriter) token(t string) {
+	if w.lineLen > 0 && w.lineLen+1+len(t) > 79 {
+		w.buf.WriteString("\n")
+		w.lineLen = 0
+	}
+	if w.lineLen > 0 {
+		w.buf.WriteString(" ")
+		w.lineLen++
+	}
+	w.buf.WriteString(t)
+	w.lineLen += len(t)
+	w.interrupted = false
+}
+
+// move writes a move with its number, if needed, as a single token.
+func (w *movetextWriter) move(pos *chess.Position, san string) {
+	if pos.Turn() == chess.White || w.interrupted {
+		san = moveNumber(pos) + " " + san
+	}
+	w.token(san)
+}
+
+// comment writes a comment as a single token. Comments and variations are
+// never wrapped so that they stay on one line, as not all readers support
+// them spanning lines.
+func (w *movetextWriter) comment(c string) {
+	w.token("{ " + c + " }")
+	w.interrupted = true
+}
+
+// variation writes a variation of already formatted movetext.
+func (w *movetextWriter) variation(v string) {
+	w.token("(" + v + ")")
+	w.interrupted = true
+}
+
+// validatePGN checks that pgn can be read back and has the same moves as g.
+func validatePGN(pgn string, g *chess.Game) error {
+	read, err := chess.PGN(strings.NewReader(pgn))
+	if err != nil {
+		return err
+	}
+
+	moves := chess.NewGame(read).Moves()
+	if len(moves) != len(g.Moves()) {
+		return fmt.Errorf("Read back %d moves instead of %d", len(moves), len(g.Moves()))
+	}
+	for i, m := range g.Moves() {
+		if moves[i].String() != m.String() {
+			return fmt.Errorf("Read back %s instead of %s at ply %d", moves[i], m, i)
+		}
+	}
+
+	return nil
+}
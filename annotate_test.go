@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/notnil/chess"
+)
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.FatalLevel)
+	os.Exit(m.Run())
+}
+
+// materialAnalyzer scores positions by material for the side to move, and
+// prefers the move that wins the most. Searches listed in fail return an
+// error, and those in badMove return a best move that isn't legal.
+type materialAnalyzer struct {
+	searches int
+	fail     map[int]bool
+	badMove  map[int]bool
+	err      error
+}
+
+func (a *materialAnalyzer) Name() string { return "material" }
+func (a *materialAnalyzer) Err() error   { return a.err }
+func (a *materialAnalyzer) Close() error { return nil }
+
+func (a *materialAnalyzer) Analyze(fen string) Result {
+	n := a.searches
+	a.searches++
+	a.err = nil
+	if a.fail[n] {
+		a.err = errors.New("engine crashed")
+		return Result{}
+	}
+
+	f, err := chess.FEN(fen)
+	if err != nil {
+		return Result{Err: err}
+	}
+	pos := chess.NewGame(f).Position()
+	if pos.Status() == chess.Checkmate {
+		return Result{Mate: 0, Score: -mateScore, BestMove: "(none)"}
+	}
+
+	var best *chess.Move
+	bestScore := 0.0
+	for _, m := range pos.ValidMoves() {
+		if s := -material(pos.Update(m)); best == nil || s > bestScore {
+			best, bestScore = m, s
+		}
+	}
+	if best == nil {
+		return Result{BestMove: "(none)"}
+	}
+
+	r := Result{Score: bestScore, BestMove: best.String(), PV: []string{best.String()}, Depth: 1}
+	if a.badMove[n] {
+		r.BestMove, r.PV = "a1a1", nil
+	}
+	return r
+}
+
+var materialValues = map[chess.PieceType]float64{
+	chess.Pawn: 1, chess.Knight: 3, chess.Bishop: 3, chess.Rook: 5, chess.Queen: 9,
+}
+
+// material returns the material balance in pawns for the side to move.
+func material(pos *chess.Position) float64 {
+	var score float64
+	for _, p := range pos.Board().SquareMap() {
+		v := materialValues[p.Type()]
+		if p.Color() != pos.Turn() {
+			v = -v
+		}
+		score += v
+	}
+	return score
+}
+
+// uciMove matches a move in UCI notation, which must never be written in PGN
+// movetext.
+var uciMove = regexp.MustCompile(`\b[a-h][1-8][a-h][1-8]`)
+
+func TestAnnotatedPGNRoundTrip(t *testing.T) {
+	pgn := `[Event "Live Chess"]
+[Site "Chess.com"]
+[White "alice"]
+[Black "bob"]
+[Result "0-1"]
+
+1. f3 e5 2. Nc3 Nc6 3. g4 Qh4# 0-1`
+
+	tests := []struct {
+		name    string
+		fail    map[int]bool
+		badMove map[int]bool
+	}{
+		{name: "all analysed"},
+		{name: "failed search", fail: map[int]bool{3: true}},
+		{name: "illegal best move", badMove: map[int]bool{2: true, 5: true}},
+	}
+
+	for _, tt := range tests {
+		read, err := chess.PGN(strings.NewReader(pgn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := chess.NewGame(read)
+
+		a := &materialAnalyzer{fail: tt.fail, badMove: tt.badMove}
+		results := analyzePositions(a, g.Positions())
+		plies := assessMoves(g, results)
+		data := Game{White: Player{Username: "alice"}, Black: Player{Username: "bob"}}
+		summaries := summarize(data, plies)
+		annotated := annotatedPGN(g, plies, summaries, a.Name())
+
+		if err := validatePGN(annotated, g); err != nil {
+			t.Errorf("%s: %v\n%s", tt.name, err, annotated)
+		}
+
+		movetext := annotated[strings.LastIndex(annotated, "]\n")+2:]
+		if m := uciMove.FindString(movetext); m != "" {
+			t.Errorf("%s: UCI move %s in movetext\n%s", tt.name, m, movetext)
+		}
+		if !strings.Contains(movetext, "Qh4#") {
+			t.Errorf("%s: mate not written\n%s", tt.name, movetext)
+		}
+
+		var unclassified int
+		for i, p := range plies {
+			if p.Class != Unclassified {
+				continue
+			}
+			unclassified++
+			if !tt.fail[i] && !tt.fail[i+1] {
+				t.Errorf("%s: ply %d unclassified", tt.name, i)
+			}
+		}
+		if want := 2 * len(tt.fail); unclassified != want {
+			t.Errorf("%s: %d unclassified plies, want %d", tt.name, unclassified, want)
+		}
+		if moves := summaries[0].Moves + summaries[1].Moves; moves != len(plies)-unclassified {
+			t.Errorf("%s: summaries count %d moves, want %d", tt.name, moves, len(plies)-unclassified)
+		}
+
+		for i := range tt.badMove {
+			if plies[i].BestMove != "" {
+				t.Errorf("%s: ply %d best move %q, want none", tt.name, i, plies[i].BestMove)
+			}
+		}
+	}
+}
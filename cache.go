@@ -306,3 +306,171 @@ func SaveArchive(archiveID string, games []Game) {
 		"count":   len(games),
 	}).Info("Saved archive to file")
 }
+
+func createPositionIndexFilename(user string) string {
+	return "positions-" + url.QueryEscape(user) + ".json"
+}
+
+func LoadPositionIndex(user string) *PositionIndex {
+	idx := newPositionIndex()
+	if !loadJSONFile(createPositionIndexFilename(user), "position index", idx) {
+		return newPositionIndex()
+	}
+
+	log.WithFields(log.Fields{
+		"user":  user,
+		"games": len(idx.Games),
+		"count": len(idx.Positions),
+	}).Info("Loaded cached position index")
+	return idx
+}
+
+func SavePositionIndex(user string, idx *PositionIndex) {
+	if saveJSONFile(createPositionIndexFilename(user), "position index", idx) {
+		log.WithFields(log.Fields{
+			"user":  user,
+			"games": len(idx.Games),
+			"count": len(idx.Positions),
+		}).Info("Saved position index to file")
+	}
+}
+
+func createAnalysesFilename(user string) string {
+	return "analyses-" + url.QueryEscape(user) + ".json"
+}
+
+// LoadAnalyses returns the user's analysed games by ID.
+func LoadAnalyses(user string) map[string]AnalysisRecord {
+	analyses := make(map[string]AnalysisRecord)
+	if !loadJSONFile(createAnalysesFilename(user), "analyses", &analyses) {
+		return make(map[string]AnalysisRecord)
+	}
+
+	log.WithFields(log.Fields{
+		"user":  user,
+		"count": len(analyses),
+	}).Info("Loaded cached analyses")
+	return analyses
+}
+
+func SaveAnalyses(user string, analyses map[string]AnalysisRecord) {
+	if saveJSONFile(createAnalysesFilename(user), "analyses", analyses) {
+		log.WithFields(log.Fields{
+			"user":  user,
+			"count": len(analyses),
+		}).Info("Saved analyses to file")
+	}
+}
+
+func createPuzzlesFilename(user string) string {
+	return "puzzles-" + url.QueryEscape(user) + ".json"
+}
+
+// LoadPuzzles returns the puzzles made from the user's games by ID, including
+// positions that failed verification, which have no solution.
+func LoadPuzzles(user string) map[string]Puzzle {
+	puzzles := make(map[string]Puzzle)
+	if !loadJSONFile(createPuzzlesFilename(user), "puzzles", &puzzles) {
+		return make(map[string]Puzzle)
+	}
+
+	log.WithFields(log.Fields{
+		"user":  user,
+		"count": len(puzzles),
+	}).Info("Loaded cached puzzles")
+	return puzzles
+}
+
+func SavePuzzles(user string, puzzles map[string]Puzzle) {
+	if saveJSONFile(createPuzzlesFilename(user), "puzzles", puzzles) {
+		log.WithFields(log.Fields{
+			"user":  user,
+			"count": len(puzzles),
+		}).Info("Saved puzzles to file")
+	}
+}
+
+func createTrainingFilename(user string) string {
+	return "training-" + url.QueryEscape(user) + ".json"
+}
+
+// LoadTraining returns the review schedule of the user's puzzles by ID.
+func LoadTraining(user string) map[string]Review {
+	reviews := make(map[string]Review)
+	if !loadJSONFile(createTrainingFilename(user), "training schedule", &reviews) {
+		return make(map[string]Review)
+	}
+
+	log.WithFields(log.Fields{
+		"user":  user,
+		"count": len(reviews),
+	}).Info("Loaded training schedule")
+	return reviews
+}
+
+func SaveTraining(user string, reviews map[string]Review) {
+	if saveJSONFile(createTrainingFilename(user), "training schedule", reviews) {
+		log.WithFields(log.Fields{
+			"user":  user,
+			"count": len(reviews),
+		}).Info("Saved training schedule to file")
+	}
+}
+
+// loadJSONFile decodes the cache file named filename into v. It returns false
+// if caching is disabled or the file can't be read, in which case v may have
+// been partly filled. what names the contents in logs.
+func loadJSONFile(filename, what string, v interface{}) bool {
+	baseDir := cacheDir()
+	if cacheDisabled || baseDir == "" {
+		return false
+	}
+
+	path := filepath.Join(baseDir, filename)
+	f, err := os.Open(path)
+	if err != nil {
+		log.WithError(err).WithField("path", path).
+			Info("Could not open cached " + what)
+		return false
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		log.WithError(err).WithField("path", path).
+			Warn("Could not read cached " + what)
+		return false
+	}
+	return true
+}
+
+// saveJSONFile writes v to the cache file named filename, through a temporary
+// file so a failed write doesn't lose what was there. It returns whether v
+// was saved.
+func saveJSONFile(filename, what string, v interface{}) bool {
+	baseDir := cacheDir()
+	if cacheDisabled || baseDir == "" {
+		return false
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.WithError(err).Warn("Could not marshal " + what)
+		return false
+	}
+
+	path := filepath.Join(baseDir, filename)
+	tmpPath := path + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		log.WithError(err).WithField("path", tmpPath).
+			Warn("Could not write " + what + " to temporary file")
+		return false
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"tmp_path": tmpPath,
+			"path":     path,
+		}).Warn("Could not rename temporary file to " + what)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+)
+
+// Classification is the quality of a move, judged by how much of the
+// expected score the player gave away by playing it.
+type Classification int
+
+const (
+	// a move next to a position the engine failed to analyse, which can't
+	// be judged
+	Unclassified Classification = iota - 1
+	Best
+	Excellent
+	Good
+	Inaccuracy
+	Mistake
+	Blunder
+)
+
+// upper bounds of expected points lost for each classification
+var classificationLimits = []struct {
+	class Classification
+	lost  float64
+}{
+	{Excellent, 0.02},
+	{Good, 0.05},
+	{Inaccuracy, 0.10},
+	{Mistake, 0.20},
+}
+
+func (c Classification) String() string {
+	switch c {
+	case Unclassified:
+		return "unclassified"
+	case Best:
+		return "best"
+	case Excellent:
+		return "excellent"
+	case Good:
+		return "good"
+	case Inaccuracy:
+		return "inaccuracy"
+	case Mistake:
+		return "mistake"
+	default:
+		return "blunder"
+	}
+}
+
+// NAG returns the traditional move suffix annotation for the classification,
+// or an empty string for moves that don't warrant one.
+func (c Classification) NAG() string {
+	switch c {
+	case Inaccuracy:
+		return "?!"
+	case Mistake:
+		return "?"
+	case Blunder:
+		return "??"
+	default:
+		return ""
+	}
+}
+
+// ClassifyMove classifies a move given whether it was the engine's choice and
+// the expected points the player lost by playing it.
+func ClassifyMove(isBest bool, lost float64) Classification {
+	if isBest {
+		return Best
+	}
+
+	for _, l := range classificationLimits {
+		if lost < l.lost {
+			return l.class
+		}
+	}
+	return Blunder
+}
+
+// ExpectedScore returns white's expected score (win = 1, draw = 0.5) in the
+// analysed position. The engine's WDL is used when it reported one, otherwise
+// the score is converted with Lichess' model.
+func (r Result) ExpectedScore() float64 {
+	if w, d, l := r.WDL[0], r.WDL[1], r.WDL[2]; w+d+l > 0 {
+		return (float64(w) + float64(d)/2) / float64(w+d+l)
+	}
+	return expectedScore(r.Score)
+}
+
+// expectedScore converts a score in pawns to an expected score using the
+// winning chances model Lichess fitted to its games, 1 / (1 + e^(-0.00368208
+// × centipawns)).
+func expectedScore(score float64) float64 {
+	return 1 / (1 + math.Exp(-0.368208*score))
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// clockComment matches the clock Chess.com records after each move, e.g.
+// {[%clk 0:02:59.9]}.
+var clockComment = regexp.MustCompile(`\[%clk\s+(\d+):(\d+):(\d+(?:\.\d+)?)\]`)
+
+var timeControlTag = regexp.MustCompile(`\[TimeControl\s+"([^"]*)"\]`)
+
+// Clocks returns the time left on the player's clock after each move, or nil
+// if the game doesn't record a clock for every move.
+func (g *Game) Clocks() []time.Duration {
+	parsedGame, err := g.Game()
+	if err != nil {
+		return nil
+	}
+
+	var clocks []time.Duration
+	for _, m := range clockComment.FindAllStringSubmatch(g.pgn, -1) {
+		h, _ := strconv.Atoi(m[1])
+		min, _ := strconv.Atoi(m[2])
+		sec, _ := strconv.ParseFloat(m[3], 64)
+		clocks = append(clocks, time.Duration(h)*time.Hour+
+			time.Duration(min)*time.Minute+
+			time.Duration(sec*float64(time.Second)))
+	}
+
+	if len(clocks) == 0 || len(clocks) != len(parsedGame.Moves()) {
+		return nil
+	}
+	return clocks
+}
+
+// clockTimeControl returns the starting time and increment of the game, read
+// from its PGN as that's where the clocks come from. Daily games have no
+// clock to speak of, so aren't timed.
+func (g *Game) clockTimeControl() (base, increment time.Duration, ok bool) {
+	tc := g.TimeControl
+	if m := timeControlTag.FindStringSubmatch(g.pgn); m != nil {
+		var err error
+		if tc, err = ParseTimeControl(m[1]); err != nil {
+			return 0, 0, false
+		}
+	}
+
+	if !tc.Timed() {
+		return 0, 0, false
+	}
+	return tc.Base, tc.Increment, true
+}
+
+// MoveTimes returns the time spent on each move, or nil if the game isn't
+// timed or has no clocks.
+func (g *Game) MoveTimes() []time.Duration {
+	base, increment, ok := g.clockTimeControl()
+	if !ok {
+		return nil
+	}
+	clocks := g.Clocks()
+	if clocks == nil {
+		return nil
+	}
+
+	times := make([]time.Duration, len(clocks))
+	for i, clock := range clocks {
+		before := base
+		if i >= 2 {
+			before = clocks[i-2]
+		}
+
+		// the increment is added once the move is made
+		times[i] = before - clock + increment
+		if times[i] < 0 {
+			times[i] = 0
+		}
+	}
+	return times
+}
+
+// addClocks records the clock and time spent on each move of the game in
+// plies, if it has them.
+func addClocks(plies []Ply, g *Game) {
+	clocks, times := g.Clocks(), g.MoveTimes()
+	if clocks == nil || times == nil || len(clocks) != len(plies) {
+		return
+	}
+
+	for i := range plies {
+		plies[i].Timed = true
+		plies[i].Clock = clocks[i]
+		plies[i].Spent = times[i]
+	}
+}
+
+// formatClock formats a duration as h:mm:ss, with tenths of a second when
+// there are any, as in [%clk] comments.
+func formatClock(d time.Duration) string {
+	d = d.Round(100 * time.Millisecond)
+	h := d / time.Hour
+	m := (d % time.Hour) / time.Minute
+	s := (d % time.Minute) / time.Second
+	tenths := (d % time.Second) / (100 * time.Millisecond)
+
+	if tenths == 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d:%02d.%d", h, m, s, tenths)
+}
+
+// timeTroubleFraction is the share of the starting time below which a player
+// is in time trouble.
+const timeTroubleFraction = 0.1
+
+// winningEval is the evaluation in pawns above which a position is counted
+// as winning.
+const winningEval = 2.0
+
+// timeBuckets split moves by the share of the starting time left before the
+// move, for comparing blunders made with and without time to think.
+var timeBuckets = []struct {
+	name string
+	min  float64
+}{
+	{"over 50%", 0.5},
+	{"20-50%", 0.2},
+	{"10-20%", 0.1},
+	{"under 10%", 0},
+}
+
+type timeBucket struct {
+	Moves    int
+	Blunders int
+}
+
+// TimeStats describes how the user manages their clock.
+type TimeStats struct {
+	Games int
+	// moves and total time spent per phase
+	PhaseMoves map[Phase]int
+	PhaseTime  map[Phase]time.Duration
+	// moves made in time trouble, out of all timed moves
+	Moves            int
+	TimeTroubleMoves int
+	// moves and blunders in analysed games, by time left
+	Buckets []timeBucket
+	// games lost on time, how many were analysed, and the analysed ones where
+	// the user was winning at the end
+	LostOnTime         int
+	LostOnTimeAnalysed int
+	LostOnTimeWinning  []Game
+}
+
+func (s *TimeStats) add(g *Game, user string, analysis AnalysisRecord) {
+	base, _, ok := g.clockTimeControl()
+	if !ok {
+		return
+	}
+	clocks, times := g.Clocks(), g.MoveTimes()
+	if clocks == nil || times == nil {
+		return
+	}
+	parsedGame, err := g.Game()
+	if err != nil {
+		return
+	}
+
+	userColor, player := chess.White, g.White
+	if g.Black.Username == user {
+		userColor, player = chess.Black, g.Black
+	}
+
+	s.Games++
+	analysed := len(analysis.Plies) == len(clocks)
+	positions := parsedGame.Positions()
+	phaseOf := gamePhases(positions)
+	for i := range clocks {
+		if positions[i].Turn() != userColor {
+			continue
+		}
+
+		phase := phaseOf[i]
+		s.PhaseMoves[phase]++
+		s.PhaseTime[phase] += times[i]
+
+		before := base
+		if i >= 2 {
+			before = clocks[i-2]
+		}
+		left := before.Seconds() / base.Seconds()
+
+		s.Moves++
+		if left < timeTroubleFraction {
+			s.TimeTroubleMoves++
+		}
+
+		if !analysed {
+			continue
+		}
+		for j, b := range timeBuckets {
+			if left >= b.min {
+				s.Buckets[j].Moves++
+				if analysis.Plies[i].Classification == Blunder.String() {
+					s.Buckets[j].Blunders++
+				}
+				break
+			}
+		}
+	}
+
+	if player.Termination != Timeout {
+		return
+	}
+	s.LostOnTime++
+	if len(analysis.Plies) == 0 {
+		return
+	}
+	s.LostOnTimeAnalysed++
+
+	last := analysis.Plies[len(analysis.Plies)-1]
+	eval, mate := last.EvalAfter, last.MateAfter
+	if userColor == chess.Black {
+		eval, mate = 0-eval, -mate
+	}
+	if mate > 0 || (mate == 0 && eval >= winningEval) {
+		s.LostOnTimeWinning = append(s.LostOnTimeWinning, *g)
+	}
+}
+
+func formatTimeStats(s TimeStats, user string) string {
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "%d timed games\n\n", s.Games)
+
+	fmt.Fprintf(buf, "%-12s %8s %6s\n", "phase", "per move", "moves")
+	for _, phase := range phases {
+		n := s.PhaseMoves[phase]
+		if n == 0 {
+			continue
+		}
+		avg := s.PhaseTime[phase] / time.Duration(n)
+		fmt.Fprintf(buf, "%-12s %7.1fs %6d\n", phase, avg.Seconds(), n)
+	}
+	fmt.Fprintln(buf)
+
+	if s.Moves > 0 {
+		fmt.Fprintf(buf, "time trouble: %d of %d moves (%.0f%%) with under %.0f%% of the starting time\n\n",
+			s.TimeTroubleMoves, s.Moves,
+			float64(s.TimeTroubleMoves)/float64(s.Moves)*100, timeTroubleFraction*100)
+	}
+
+	fmt.Fprintf(buf, "%-12s %6s %8s %6s\n", "time left", "moves", "blunders", "rate")
+	for i, b := range s.Buckets {
+		rate := "-"
+		if b.Moves > 0 {
+			rate = fmt.Sprintf("%.1f%%", float64(b.Blunders)/float64(b.Moves)*100)
+		}
+		fmt.Fprintf(buf, "%-12s %6d %8d %6s\n", timeBuckets[i].name, b.Moves, b.Blunders, rate)
+	}
+	fmt.Fprintln(buf)
+
+	fmt.Fprintf(buf, "lost on time: %d games, %d analysed, %d winning at the end\n",
+		s.LostOnTime, s.LostOnTimeAnalysed, len(s.LostOnTimeWinning))
+	for _, g := range s.LostOnTimeWinning {
+		fmt.Fprintf(buf, "  %s\n", formatGame(g, user))
+	}
+	return buf.String()
+}
+
+// StatsTime reports how the user manages their clock in timed games matching
+// the filters. Blunders and evaluations come from games already analysed.
+func StatsTime(cfg config) {
+	analyses := LoadAnalyses(cfg.user)
+
+	s := TimeStats{
+		PhaseMoves: make(map[Phase]int),
+		PhaseTime:  make(map[Phase]time.Duration),
+		Buckets:    make([]timeBucket, len(timeBuckets)),
+	}
+	games := matchingGames(cfg)
+	for i := range games {
+		s.add(&games[i], cfg.user, analyses[games[i].ID()])
+	}
+
+	fmt.Print(formatTimeStats(s, cfg.user))
+}
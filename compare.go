@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/apex/log"
+	"github.com/notnil/chess"
+)
+
+// Compare analyses a game with two engines and lists the moves where they
+// classify the move played differently, ignoring moves both consider good.
+func Compare(cfg config) {
+	games := gamesToAnalyze(cfg)
+
+	a, err := NewEngine(cfg.engine, cfg.depth, cfg.timeout)
+	if err != nil {
+		log.WithError(err).WithField("engine", cfg.engine).
+			Fatal("Could not initialise analysis engine")
+	}
+	defer a.Close()
+
+	b, err := NewEngine(cfg.compare, cfg.depth, cfg.timeout)
+	if err != nil {
+		// deferred calls don't run on Fatal
+		a.Close()
+		log.WithError(err).WithField("engine", cfg.compare).
+			Fatal("Could not initialise analysis engine")
+	}
+	defer b.Close()
+
+	for i, data := range games {
+		if i > 0 {
+			fmt.Println()
+		}
+		compareGame(a, b, data, cfg)
+	}
+}
+
+func compareGame(a, b Analyzer, data Game, cfg config) {
+	g, err := data.Game()
+	if err != nil {
+		log.WithError(err).WithField("url", data.URL.String()).
+			Warn("Could not parse game to analyse")
+		return
+	}
+
+	positions := g.Positions()
+	log.WithFields(log.Fields{
+		"url":     data.URL.String(),
+		"count":   len(positions),
+		"engines": []string{a.Name(), b.Name()},
+		"depth":   cfg.depth,
+		"timeout": cfg.timeout,
+	}).Info("Starting comparison")
+	pliesA := assessMoves(g, analyzePositions(a, positions))
+	pliesB := assessMoves(g, analyzePositions(b, positions))
+
+	// describes how an engine assessed a move, with the change in evaluation
+	// from the side that moved so that a negative change is always a loss
+	assessment := func(p Ply) string {
+		sign := 1.0
+		if p.Position.Turn() == chess.Black {
+			sign = -1
+		}
+
+		// a change to or from a forced mate has no size, so give both
+		change := fmt.Sprintf("%+.2f", sign*(p.After.Score-p.Before.Score))
+		if isMate(p.Before) || isMate(p.After) {
+			change = moverEval(p.Before, sign) + " → " + moverEval(p.After, sign)
+		}
+		return fmt.Sprintf("%s %s (%s)", change, p.Class, p.BestMove)
+	}
+
+	fmt.Println(data.URL)
+	fmt.Printf("%-12s %-32s %s\n", "", a.Name(), b.Name())
+	var count int
+	for i := range pliesA {
+		pa, pb := pliesA[i], pliesB[i]
+		if pa.Class == pb.Class || (pa.Class < Inaccuracy && pb.Class < Inaccuracy) {
+			continue
+		}
+
+		turn := moveNumber(pa.Position)
+
+		fmt.Printf("%-12s %-32s %s\n",
+			turn+" "+pa.SAN, assessment(pa), assessment(pb))
+		count++
+	}
+
+	log.WithFields(log.Fields{
+		"url":   data.URL.String(),
+		"count": count,
+	}).Info("Finished comparison")
+}
+
+// isMate returns whether r is a forced mate, including one on the board.
+func isMate(r Result) bool {
+	return math.Abs(r.Score) >= mateScore
+}
+
+// moverEval formats a white's perspective result from the side that moved,
+// given as sign, with mates as e.g. #3 when they mate in 3, #-2 when they
+// are mated in 2, or mate if they delivered it.
+func moverEval(r Result, sign float64) string {
+	switch {
+	case r.Mate != 0:
+		return fmt.Sprintf("#%d", int(sign)*r.Mate)
+	case isMate(r):
+		return "mate"
+	}
+	return fmt.Sprintf("%+.2f", sign*r.Score)
+}
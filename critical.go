@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+// maxCriticalMoments is how many moments are listed for each game.
+const maxCriticalMoments = 5
+
+// MomentKind is why a move was a critical moment in the game.
+type MomentKind int
+
+const (
+	// a large loss that didn't change who was better
+	Swing MomentKind = iota
+	// an equal position turned into a lost one, or a winning one into a
+	// draw or loss
+	TurningPoint
+	// a winning position let go
+	MissedWin
+)
+
+func (k MomentKind) String() string {
+	switch k {
+	case MissedWin:
+		return "missed win"
+	case TurningPoint:
+		return "turning point"
+	default:
+		return "swing"
+	}
+}
+
+// CriticalMoment is a move that changed the course of the game.
+type CriticalMoment struct {
+	// index of the move in the game's plies
+	Index int
+	Kind  MomentKind
+}
+
+// evalOutcome returns whether the position is winning (1), losing (-1) or
+// neither (0) for c.
+func evalOutcome(r Result, c chess.Color) int {
+	score, mate := r.Score, r.Mate
+	if c == chess.Black {
+		score, mate = 0-score, -mate
+	}
+
+	switch {
+	case mate > 0, mate == 0 && score >= winningEval:
+		return 1
+	case mate < 0, mate == 0 && score <= -winningEval:
+		return -1
+	}
+	return 0
+}
+
+// momentKind returns what kind of critical moment the move would be.
+func momentKind(p Ply) MomentKind {
+	c := p.Position.Turn()
+	before, after := evalOutcome(p.Before, c), evalOutcome(p.After, c)
+	switch {
+	case before == 1 && after < 1:
+		return MissedWin
+	case after < before:
+		return TurningPoint
+	}
+	return Swing
+}
+
+// criticalMoments picks the moves that mattered most in an analysed game,
+// in the order they were played. Missed wins and turning points come first,
+// then the largest swings, up to maxCriticalMoments. Only inaccuracies and
+// worse are considered.
+func criticalMoments(plies []Ply) []CriticalMoment {
+	var moments []CriticalMoment
+	for i, p := range plies {
+		if p.Class < Inaccuracy || !p.Before.hasEval() {
+			continue
+		}
+		moments = append(moments, CriticalMoment{Index: i, Kind: momentKind(p)})
+	}
+
+	sort.SliceStable(moments, func(i, j int) bool {
+		if moments[i].Kind != moments[j].Kind {
+			return moments[i].Kind > moments[j].Kind
+		}
+		return plies[moments[i].Index].Lost > plies[moments[j].Index].Lost
+	})
+	if len(moments) > maxCriticalMoments {
+		moments = moments[:maxCriticalMoments]
+	}
+
+	sort.Slice(moments, func(i, j int) bool {
+		return moments[i].Index < moments[j].Index
+	})
+	return moments
+}
+
+// engineLine formats the engine's line from before the move, or just its
+// preferred move if it didn't report one.
+func engineLine(p Ply) string {
+	line := p.BestLine
+	if len(line) == 0 && p.BestMove != "" {
+		line = []string{p.BestMove}
+	}
+	return variationString(p.Position, line)
+}
+
+// formatCriticalMoments lists each moment with the position before the move,
+// the move played and what the engine wanted instead.
+func formatCriticalMoments(moments []CriticalMoment, plies []Ply) string {
+	buf := &strings.Builder{}
+	fmt.Fprintln(buf, "Critical moments")
+	if len(moments) == 0 {
+		fmt.Fprintln(buf, "none")
+	}
+
+	for _, m := range moments {
+		p := plies[m.Index]
+		fmt.Fprintln(buf)
+		fmt.Fprintf(buf, "%s %s%s %s, eval %s → %s (%+.0f%%)\n",
+			moveNumber(p.Position), p.SAN, p.Class.NAG(), m.Kind,
+			p.Before.eval(), p.After.eval(), -p.Lost*100)
+		fmt.Fprintf(buf, "FEN    %s\n", p.Position)
+		fmt.Fprintf(buf, "engine %s\n", engineLine(p))
+		if len(p.Missed) > 0 {
+			fmt.Fprintf(buf, "missed %s\n", strings.Join(motifStrings(p.Missed), ", "))
+		}
+		if len(p.Conceded) > 0 {
+			fmt.Fprintf(buf, "allows %s\n", strings.Join(motifStrings(p.Conceded), ", "))
+		}
+		fmt.Fprint(buf, strings.TrimPrefix(p.Position.Board().Draw(), "\n"))
+	}
+	return buf.String()
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/notnil/chess"
+	"github.com/notnil/chess/opening"
+)
+
+// Opening is a named opening from the Encyclopaedia of Chess Openings.
+type Opening struct {
+	ECO  string
+	Name string
+}
+
+func (o Opening) String() string {
+	if o.Name == "" {
+		return o.ECO
+	}
+	return o.ECO + " " + o.Name
+}
+
+// ecoBook maps the hash of every position in the ECO table to the opening
+// reaching it, so openings are recognised whatever the move order.
+var ecoBook struct {
+	once      sync.Once
+	positions map[uint64]Opening
+	// length of the longest line in the table
+	maxPlies int
+}
+
+func loadECOBook() {
+	ecoBook.positions = make(map[uint64]Opening)
+
+	// shorter lines first so a position reached by several lines is named
+	// after the most direct one
+	type line struct {
+		opening Opening
+		moves   []string
+	}
+	var lines []line
+	for _, o := range opening.NewBookECO().Possible(nil) {
+		var moves []string
+		for _, s := range strings.Fields(o.PGN()) {
+			moves = append(moves, s[strings.Index(s, ".")+1:])
+		}
+		lines = append(lines, line{Opening{o.Code(), cleanECOName(o.Title(), o.Code())}, moves})
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		if len(lines[i].moves) != len(lines[j].moves) {
+			return len(lines[i].moves) < len(lines[j].moves)
+		}
+		if lines[i].opening.ECO != lines[j].opening.ECO {
+			return lines[i].opening.ECO < lines[j].opening.ECO
+		}
+		return lines[i].opening.Name < lines[j].opening.Name
+	})
+
+	// names and positions of each line and the lines leading to them, as
+	// lines only named by their code take the name of the line they extend,
+	// and replaying every line from the start is slow
+	names := make(map[string]string)
+	positions := map[string]*chess.Position{"": chess.StartingPosition()}
+	for _, l := range lines {
+		if l.opening.Name == "" {
+			for i := len(l.moves) - 1; i > 0; i-- {
+				if name := names[strings.Join(l.moves[:i], " ")]; name != "" {
+					l.opening.Name = name
+					break
+				}
+			}
+		}
+		names[strings.Join(l.moves, " ")] = l.opening.Name
+
+		pos := chess.StartingPosition()
+		for i, s := range l.moves {
+			key := strings.Join(l.moves[:i+1], " ")
+			if p, ok := positions[key]; ok {
+				pos = p
+				continue
+			}
+
+			m, err := chess.UCINotation{}.Decode(pos, s)
+			if err != nil {
+				log.WithError(err).WithField("opening", l.opening).
+					Warn("Invalid move in ECO table")
+				break
+			}
+			pos = pos.Update(m)
+			positions[key] = pos
+		}
+
+		h := PositionHash(pos)
+		if _, ok := ecoBook.positions[h]; !ok {
+			ecoBook.positions[h] = l.opening
+		}
+		if len(l.moves) > ecoBook.maxPlies {
+			ecoBook.maxPlies = len(l.moves)
+		}
+	}
+}
+
+// cleanECOName tidies a name from the ECO table, which lists alternative
+// names separated by semicolons and variations before their opening, e.g.
+// "Moscow Variation, Sicilian; Canal Attack", into "Sicilian: Moscow
+// Variation". Names that are just the code are dropped.
+func cleanECOName(name, code string) string {
+	name = strings.ReplaceAll(name, "\u00ef\u00bf\u00bd", "")
+	if i := strings.Index(name, ";"); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.TrimSpace(name)
+	if name == code {
+		return ""
+	}
+	if i := strings.LastIndex(name, ", "); i >= 0 {
+		name = name[i+2:] + ": " + name[:i]
+	}
+	return name
+}
+
+// classifyOpening returns the opening of the last position in the table that
+// the game reached.
+func classifyOpening(positions []*chess.Position) (Opening, bool) {
+	ecoBook.once.Do(loadECOBook)
+
+	var o Opening
+	var found bool
+	// transpositions can reach a book position a few moves late
+	for i := 1; i < len(positions) && i <= ecoBook.maxPlies+4; i++ {
+		if match, ok := ecoBook.positions[PositionHash(positions[i])]; ok {
+			o, found = match, true
+		}
+	}
+	return o, found
+}
+
+// Opening classifies the game by its moves, falling back to the ECO headers
+// of the PGN for games that leave the table immediately.
+func (g *Game) Opening() Opening {
+	parsedGame, err := g.Game()
+	if err != nil {
+		return Opening{}
+	}
+
+	if o, ok := classifyOpening(parsedGame.Positions()); ok {
+		return o
+	}
+
+	var o Opening
+	if t := parsedGame.GetTagPair("ECO"); t != nil {
+		o.ECO = t.Value
+	}
+	if t := parsedGame.GetTagPair("ECOUrl"); t != nil {
+		if u, err := url.Parse(t.Value); err == nil {
+			o.Name = strings.ReplaceAll(path.Base(u.Path), "-", " ")
+		}
+	}
+	return o
+}
+
+// matchOpening returns whether o matches s, which is an ECO code or prefix
+// of one (B, B2, B20), a range of codes (B20-B99), or part of the name.
+func matchOpening(o Opening, s string) bool {
+	if isECOCode(s) {
+		return strings.HasPrefix(o.ECO, strings.ToUpper(s))
+	}
+	if i := strings.Index(s, "-"); i > 0 && isECOCode(s[:i]) && isECOCode(s[i+1:]) {
+		min, max := strings.ToUpper(s[:i]), strings.ToUpper(s[i+1:])
+		code := o.ECO
+		if len(code) > len(max) {
+			code = code[:len(max)]
+		}
+		return o.ECO != "" && o.ECO >= min && code <= max
+	}
+	return strings.Contains(strings.ToLower(o.Name), strings.ToLower(s))
+}
+
+// isECOCode returns whether s is an ECO code or prefix of one.
+func isECOCode(s string) bool {
+	if len(s) == 0 || len(s) > 3 {
+		return false
+	}
+	if c := s[0] | 0x20; c < 'a' || c > 'e' {
+		return false
+	}
+	for _, c := range s[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package main
+
+import "testing"
+
+func TestClassifyOpening(t *testing.T) {
+	tests := []struct {
+		moves string
+		want  Opening
+		found bool
+	}{
+		{"a3", Opening{"A00", "Anderssen's Opening"}, true},
+		{"e4 e5 Ke2", Opening{"C20", "King Pawn Game"}, true},
+		{"e4 c5 Nf3 d6", Opening{"B50", "Sicilian: Modern Variation"}, true},
+		// transposed into from another move order
+		{"Nf3 d6 e4 c5", Opening{"B50", "Sicilian: Modern Variation"}, true},
+		{"d4 Nf6 c4 g6 Nc3 d5", Opening{"D80", "Grunfeld Defense"}, true},
+		// leaving the book keeps the last opening reached
+		{"a3 h5 h4 a6 Ra2", Opening{"A00", "Anderssen's Opening"}, true},
+		// the table has no line ending after 1. e4 c5
+		{"e4 c5", Opening{}, false},
+		{"", Opening{}, false},
+	}
+
+	for _, tt := range tests {
+		got, found := classifyOpening(gameAfter(t, tt.moves).Positions())
+		if got != tt.want || found != tt.found {
+			t.Errorf("classifyOpening(%q) = %v, %v, want %v, %v", tt.moves, got, found, tt.want, tt.found)
+		}
+	}
+}
+
+func TestCleanECOName(t *testing.T) {
+	tests := []struct {
+		name, code string
+		want       string
+	}{
+		{"Moscow Variation, Sicilian; Canal Attack", "B51", "Sicilian: Moscow Variation"},
+		{"Grunfeld Defense", "D80", "Grunfeld Defense"},
+		{"Anderssen's Opening", "A00", "Anderssen's Opening"},
+		{"B20", "B20", ""},
+		{" B20 ", "B20", ""},
+	}
+
+	for _, tt := range tests {
+		if got := cleanECOName(tt.name, tt.code); got != tt.want {
+			t.Errorf("cleanECOName(%q, %q) = %q, want %q", tt.name, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestMatchOpening(t *testing.T) {
+	sicilian := Opening{"B50", "Sicilian: Modern Variation"}
+	tests := []struct {
+		o     Opening
+		s     string
+		match bool
+	}{
+		{sicilian, "B", true},
+		{sicilian, "b5", true},
+		{sicilian, "B50", true},
+		{sicilian, "B51", false},
+		{sicilian, "C", false},
+		{sicilian, "B20-B99", true},
+		{sicilian, "b20-b99", true},
+		{sicilian, "B50-B50", true},
+		{sicilian, "B51-B99", false},
+		{sicilian, "A00-B49", false},
+		{sicilian, "A-B", true},
+		{sicilian, "B5-C", true},
+		{sicilian, "sicilian", true},
+		{sicilian, "Modern", true},
+		{sicilian, "French", false},
+		{Opening{Name: "Sicilian"}, "B20-B99", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchOpening(tt.o, tt.s); got != tt.match {
+			t.Errorf("matchOpening(%v, %q) = %v, want %v", tt.o, tt.s, got, tt.match)
+		}
+	}
+}
+
+func TestIsECOCode(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"A", true},
+		{"e", true},
+		{"B2", true},
+		{"C45", true},
+		{"", false},
+		{"F00", false},
+		{"B2x", false},
+		{"B200", false},
+		{"Sicilian", false},
+	}
+
+	for _, tt := range tests {
+		if got := isECOCode(tt.s); got != tt.want {
+			t.Errorf("isECOCode(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
@@ -2,10 +2,9 @@ package main
 
 import (
 	"bufio"
-	"context"
+	"errors"
 	"fmt"
 	"io"
-	"math"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -15,23 +14,109 @@ import (
 )
 
 const (
-	processName = "stockfish"
+	defaultEngine = "stockfish"
+
+	// score given to forced mates, in pawns
+	mateScore = 100
+
+	// time to wait for an engine to respond during start up
+	startTimeout = 10 * time.Second
+	// time to wait for a search to finish after sending stop
+	stopGracePeriod = 2 * time.Second
+	// time to wait for an engine to exit after sending quit
+	quitTimeout = 2 * time.Second
+)
+
+var (
+	ErrEngineExited = errors.New("Engine exited unexpectedly")
+	ErrEngineHung   = errors.New("Engine stopped responding")
 )
 
+// Analyzer evaluates board positions given in FEN. Engine implements it for
+// any UCI engine, but anything that can score a position will do.
+type Analyzer interface {
+	Name() string
+	Analyze(fen string) Result
+	Err() error
+	Close() error
+}
+
+// engineOptions are the UCI options sent to known engines on start up.
+// Engines not listed here are started with no options.
+var engineOptions = map[string][]string{
+	"stockfish": {
+		"Threads value 8",
+		"UCI_AnalyseMode value true",
+		"UCI_ShowWDL value true",
+		"Use NNUE value true",
+	},
+	"lc0": {
+		"UCI_AnalyseMode value true",
+		"UCI_ShowWDL value true",
+	},
+	"dragon": {
+		"Threads value 8",
+		"UCI_AnalyseMode value true",
+	},
+	"berserk": {
+		"Threads value 8",
+	},
+}
+
+// ParseEngineSpec splits an engine spec of the form name[=path] into its
+// name and the path of the executable to run. If no path is given, the name
+// is used as the executable.
+func ParseEngineSpec(spec string) (name string, path string) {
+	if spec == "" {
+		spec = defaultEngine
+	}
+
+	if i := strings.Index(spec, "="); i >= 0 {
+		return spec[:i], spec[i+1:]
+	}
+	return spec, spec
+}
+
 type Result struct {
-	Score    float64
+	Score float64
+	// moves until mate, negative if the side to move is getting mated
+	Mate int
+	// win/draw/loss per mille, if reported by the engine
+	WDL      [3]int
 	BestMove string
-	Depth    int
-	Time     time.Duration
-	Err      error
+	// principal variation in UCI notation, starting with the best move
+	PV    []string
+	Depth int
+	Time  time.Duration
+	Err   error
+
+	// the other lines searched when MultiPV is set, best first
+	Alternatives []Result
 }
 
+// Analyze evaluates a single position. If the engine has crashed, either
+// before or during the search, it is restarted and the search is retried
+// once.
 func (e *Engine) Analyze(fen string) Result {
-	var res Result
-	if e.err != nil {
-		return res
+	if e.err == nil {
+		res := e.analyze(fen)
+		if e.err == nil {
+			return res
+		}
+	}
+
+	log.WithError(e.err).WithField("engine", e.name).
+		Warn("Engine failed, restarting")
+	if err := e.restart(); err != nil {
+		e.err = err
+		return Result{}
 	}
 
+	return e.analyze(fen)
+}
+
+func (e *Engine) analyze(fen string) Result {
+	var res Result
 	start := time.Now()
 
 	e.send("ucinewgame\n")
@@ -41,13 +126,57 @@ func (e *Engine) Analyze(fen string) Result {
 	e.send(e.searchCmd)
 
 	data := e.readUntilWithTimeout("bestmove")
+	if e.err != nil {
+		return res
+	}
+	if len(data) < 2 {
+		res.Err = fmt.Errorf("Unexpected engine output %q", data)
+		return res
+	}
+
+	// the last info line with a score for each line searched, falling back
+	// to the line before bestmove for engines that don't report one
+	lines := make(map[int]string)
+	for _, line := range data[:len(data)-1] {
+		if strings.HasPrefix(line, "info ") && strings.Contains(line, " score ") {
+			lines[multiPVIndex(line)] = line
+		}
+	}
+	if len(lines) == 0 {
+		lines[1] = data[len(data)-2]
+	}
+
+	res = parseInfo(lines[1])
+	for i := 2; lines[i] != ""; i++ {
+		res.Alternatives = append(res.Alternatives, parseInfo(lines[i]))
+	}
 
-	// parse cp out of info string
+	// parse best move
+	bestMoveStr := data[len(data)-1]
+	bestMoveArr := strings.Split(bestMoveStr, " ")
+	res.BestMove = "(none)"
+	if len(bestMoveArr) >= 2 {
+		res.BestMove = bestMoveArr[1]
+	}
+
+	res.Time = time.Since(start)
+	return res
+}
+
+// parseInfo reads the evaluation and line out of an info string.
+func parseInfo(info string) Result {
+	var res Result
 	curKey := ""
-	infoStr := data[len(data)-2]
-	for _, v := range strings.Split(infoStr, " ") {
+	wdlIndex := 0
+	for _, v := range strings.Split(info, " ") {
+		if curKey == "pv" {
+			// the rest of the line is the variation
+			res.PV = append(res.PV, v)
+			continue
+		}
+
 		switch v {
-		case "cp", "mate", "depth":
+		case "cp", "mate", "depth", "wdl", "pv":
 			curKey = v
 			continue
 		}
@@ -57,82 +186,186 @@ func (e *Engine) Analyze(fen string) Result {
 			res.Score, res.Err = strconv.ParseFloat(v, 64)
 			res.Score /= 100
 		case "mate":
-			res.Score, res.Err = strconv.ParseFloat(v, 64)
-			res.Score /= math.Abs(res.Score) * 100
+			res.Mate, res.Err = strconv.Atoi(v)
+			res.Score = mateScore
+			if res.Mate <= 0 {
+				res.Score = -mateScore
+			}
 		case "depth":
 			res.Depth, res.Err = strconv.Atoi(v)
+		case "wdl":
+			res.WDL[wdlIndex], res.Err = strconv.Atoi(v)
+			wdlIndex++
+			if wdlIndex < len(res.WDL) {
+				continue
+			}
 		}
 
 		curKey = ""
 	}
+	return res
+}
 
-	// parse best move
-	bestMoveStr := data[len(data)-1]
-	bestMoveArr := strings.Split(bestMoveStr, " ")
-	res.BestMove = "(none)"
-	if len(bestMoveArr) >= 2 {
-		res.BestMove = bestMoveArr[1]
+// multiPVIndex returns which line an info string is about, counting from 1.
+func multiPVIndex(info string) int {
+	fields := strings.Fields(info)
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] == "multipv" {
+			if n, err := strconv.Atoi(fields[i+1]); err == nil {
+				return n
+			}
+		}
 	}
-
-	res.Time = time.Since(start)
-	return res
+	return 1
 }
 
 type Engine struct {
+	name      string
+	path      string
 	searchCmd string
 	timeout   time.Duration
+	multiPV   int
 
-	cmd     *exec.Cmd
-	stdin   io.WriteCloser
-	stdout  io.ReadCloser
-	scanner *bufio.Scanner
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	// lines from the engine's stdout, closed when stdout is closed, and the
+	// error reading it, sent before lines is closed. Both belong to the
+	// current process, so a restart never sees the old reader's error.
+	lines   chan string
+	readErr chan error
 
 	err error
 }
 
-func NewEngine(depth int, timeout time.Duration) (*Engine, error) {
-	cmd := exec.Command(processName)
+func NewEngine(spec string, depth int, timeout time.Duration) (*Engine, error) {
+	name, path := ParseEngineSpec(spec)
+	e := &Engine{
+		name:      name,
+		path:      path,
+		searchCmd: fmt.Sprintf("go depth %d\n", depth),
+		timeout:   timeout,
+	}
+
+	if err := e.start(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *Engine) start() error {
+	cmd := exec.Command(e.path)
 
 	in, err := cmd.StdinPipe()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	out, err := cmd.StdoutPipe()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	err = cmd.Start()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	e := &Engine{
-		cmd:       cmd,
-		stdin:     in,
-		stdout:    out,
-		scanner:   bufio.NewScanner(out),
-		searchCmd: fmt.Sprintf("go depth %d\n", depth),
-		timeout:   timeout,
-	}
+	e.cmd = cmd
+	e.stdin = in
+	e.lines = make(chan string)
+	e.readErr = make(chan error, 1)
+	e.err = nil
+	go readLines(out, e.lines, e.readErr)
 
 	e.send("uci\n")
-	e.readUntil("uciok")
+	e.expect("uciok", startTimeout)
+
+	for _, o := range engineOptions[e.name] {
+		e.send("setoption name " + o + "\n")
+	}
+	if e.multiPV > 1 {
+		e.send(fmt.Sprintf("setoption name MultiPV value %d\n", e.multiPV))
+	}
+	e.send("isready\n")
+	e.expect("readyok", startTimeout)
+
+	if e.err != nil {
+		e.Close()
+	}
+	return e.err
+}
 
-	e.send("setoption name Threads value 8\n")
-	e.send("setoption name UCI_AnalyseMode value true\n")
-	e.send("setoption name Use NNUE value true\n")
+// SetMultiPV makes the engine search the best n lines of every position,
+// with all but the best returned as alternatives.
+func (e *Engine) SetMultiPV(n int) error {
+	e.multiPV = n
+	e.send(fmt.Sprintf("setoption name MultiPV value %d\n", n))
 	e.send("isready\n")
-	e.readUntil("readyok")
+	e.expect("readyok", startTimeout)
+	return e.err
+}
 
-	return e, e.err
+func (e *Engine) restart() error {
+	if err := e.Close(); err != nil {
+		log.WithError(err).WithField("engine", e.name).
+			Debug("Engine did not exit cleanly")
+	}
+	return e.start()
+}
+
+// Close asks the engine to quit and waits for the process to exit, killing it
+// if it takes too long.
+func (e *Engine) Close() error {
+	if e.cmd == nil {
+		return nil
+	}
+
+	// the engine may already be gone, so errors here are expected
+	io.WriteString(e.stdin, "quit\n")
+	e.stdin.Close()
+
+	// stdout must be fully read before the process can be reaped. Killing
+	// the engine closes it, so keep reading until then either way.
+	timer := time.NewTimer(quitTimeout)
+	defer timer.Stop()
+	for open := true; open; {
+		select {
+		case _, open = <-e.lines:
+		case <-timer.C:
+			log.WithField("engine", e.name).Warn("Engine did not quit, killing")
+			e.cmd.Process.Kill()
+		}
+	}
+
+	err := e.cmd.Wait()
+	e.cmd = nil
+	return err
+}
+
+func (e *Engine) Name() string {
+	return e.name
 }
 
 func (e *Engine) Err() error {
 	return e.err
 }
 
+// readLines forwards lines from the engine to lines until the engine closes
+// stdout, then sends the error reading it, if any, to errs. There is one of
+// these per engine process.
+func readLines(r io.Reader, lines chan<- string, errs chan<- error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		log.WithField("engine", "rx").Debug(line)
+		lines <- line
+	}
+
+	// sent before close so it is there for anyone who sees lines closed
+	errs <- scanner.Err()
+	close(lines)
+}
+
 func (e *Engine) send(data string) {
 	if e.err != nil {
 		return
@@ -141,40 +374,65 @@ func (e *Engine) send(data string) {
 	log.WithField("engine", "tx").Debug(data)
 }
 
-func (e *Engine) readUntil(prefix string) []string {
+// readUntil collects lines from the engine up to and including the first one
+// starting with prefix. It returns false if timeout elapses first.
+func (e *Engine) readUntil(prefix string, timeout time.Duration) ([]string, bool) {
 	if e.err != nil {
-		return nil
+		return nil, false
 	}
 
-	var out []string
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 
-	var line string
-	for !strings.HasPrefix(line, prefix) {
-		e.scanner.Scan()
-		line = e.scanner.Text()
-		out = append(out, line)
-		log.WithField("engine", "rx").Debug(line)
+	var out []string
+	for {
+		select {
+		case line, ok := <-e.lines:
+			if !ok {
+				e.err = <-e.readErr
+				if e.err == nil {
+					e.err = ErrEngineExited
+				}
+				return out, false
+			}
+
+			out = append(out, line)
+			if strings.HasPrefix(line, prefix) {
+				return out, true
+			}
+		case <-timer.C:
+			return out, false
+		}
 	}
+}
 
-	e.err = e.scanner.Err()
+// expect is readUntil for commands that should always finish in time.
+func (e *Engine) expect(prefix string, timeout time.Duration) []string {
+	out, ok := e.readUntil(prefix, timeout)
+	if !ok && e.err == nil {
+		e.kill()
+	}
 	return out
 }
 
+// readUntilWithTimeout waits for a search to finish, stopping it once the
+// timeout elapses. Engines that don't stop within the grace period are killed.
 func (e *Engine) readUntilWithTimeout(prefix string) []string {
-	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
-	defer cancel()
-
-	c := make(chan []string)
-	go func() {
-		c <- e.readUntil(prefix)
-		close(c)
-	}()
-
-	select {
-	case <-ctx.Done():
-		e.send("stop\n")
-		return <-c
-	case data := <-c:
-		return data
+	out, ok := e.readUntil(prefix, e.timeout)
+	if ok || e.err != nil {
+		return out
+	}
+
+	e.send("stop\n")
+	rest := e.expect(prefix, stopGracePeriod)
+	return append(out, rest...)
+}
+
+func (e *Engine) kill() {
+	log.WithField("engine", e.name).Warn("Engine stopped responding, killing")
+	e.err = ErrEngineHung
+	if err := e.cmd.Process.Kill(); err != nil {
+		log.WithError(err).WithField("engine", e.name).
+			Warn("Could not kill engine")
 	}
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseInfo(t *testing.T) {
+	tests := []struct {
+		info string
+		want Result
+		err  bool
+	}{
+		{
+			info: "info depth 20 seldepth 28 multipv 1 score cp 35 nodes 1000 pv e2e4 e7e5 g1f3",
+			want: Result{Score: 0.35, Depth: 20, PV: []string{"e2e4", "e7e5", "g1f3"}},
+		},
+		{
+			info: "info depth 12 score cp -120 wdl 20 300 680 pv d7d5",
+			want: Result{Score: -1.2, Depth: 12, WDL: [3]int{20, 300, 680}, PV: []string{"d7d5"}},
+		},
+		{
+			info: "info depth 30 score mate 3 pv h5f7",
+			want: Result{Score: mateScore, Mate: 3, Depth: 30, PV: []string{"h5f7"}},
+		},
+		{
+			info: "info depth 30 score mate -2 pv e1f1 d8h4",
+			want: Result{Score: -mateScore, Mate: -2, Depth: 30, PV: []string{"e1f1", "d8h4"}},
+		},
+		{
+			info: "info depth 0 score mate 0",
+			want: Result{Score: -mateScore},
+		},
+		{
+			info: "info depth 5 seldepth 9 score cp 0",
+			want: Result{Depth: 5},
+		},
+		{
+			info: "info depth 5 score cp x",
+			want: Result{Depth: 5},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		got := parseInfo(tt.info)
+		if (got.Err != nil) != tt.err {
+			t.Errorf("parseInfo(%q) error = %v, want error %v", tt.info, got.Err, tt.err)
+		}
+		if got.Score != tt.want.Score || got.Mate != tt.want.Mate ||
+			got.Depth != tt.want.Depth || got.WDL != tt.want.WDL {
+			t.Errorf("parseInfo(%q) = score %v mate %d depth %d wdl %v, want %v %d %d %v",
+				tt.info, got.Score, got.Mate, got.Depth, got.WDL,
+				tt.want.Score, tt.want.Mate, tt.want.Depth, tt.want.WDL)
+		}
+		if strings.Join(got.PV, " ") != strings.Join(tt.want.PV, " ") {
+			t.Errorf("parseInfo(%q) pv = %v, want %v", tt.info, got.PV, tt.want.PV)
+		}
+	}
+}
+
+func TestMultiPVIndex(t *testing.T) {
+	tests := []struct {
+		info string
+		want int
+	}{
+		{"info depth 20 multipv 1 score cp 35 pv e2e4", 1},
+		{"info depth 20 multipv 3 score cp 10 pv d2d4", 3},
+		{"info depth 20 score cp 35 pv e2e4", 1},
+		{"info depth 20 multipv x score cp 35", 1},
+		{"info depth 20 multipv", 1},
+	}
+
+	for _, tt := range tests {
+		if got := multiPVIndex(tt.info); got != tt.want {
+			t.Errorf("multiPVIndex(%q) = %d, want %d", tt.info, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/notnil/chess"
+)
+
+// moveStats are the results of games after a move was played.
+type moveStats struct {
+	SAN string
+	ResultStats
+}
+
+func (s moveStats) percent(n int) float64 {
+	if s.Games == 0 {
+		return 0
+	}
+	return float64(n) / float64(s.Games) * 100
+}
+
+func (s moveStats) AverageOpponentRating() int {
+	if s.Games == 0 {
+		return 0
+	}
+	return s.opponentRatings / s.Games
+}
+
+// Explore lists the moves played from a position in the user's games, like
+// an opening explorer. The position is the one reached by -q or given by
+// -fen, or the starting position. When interactive, moves can be entered to
+// drill down into the tree.
+func Explore(cfg config) {
+	pos := cfg.filter.Board
+	if pos == nil {
+		pos = chess.StartingPosition()
+	}
+
+	// the position is where exploring starts rather than a filter
+	filter := cfg.filter
+	filter.Opening = nil
+	filter.Position = 0
+	cfg.filter = filter
+	games := matchingGames(cfg)
+
+	if !cfg.interactive {
+		fmt.Print(formatExplorer(pos, games, cfg.user))
+		return
+	}
+
+	var history []*chess.Position
+	in := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Println(pos.Board().Draw())
+		fmt.Print(formatExplorer(pos, games, cfg.user))
+		fmt.Print("\nmove, back or quit> ")
+
+		if !in.Scan() {
+			fmt.Println()
+			return
+		}
+
+		input := strings.TrimSpace(in.Text())
+		switch input {
+		case "", "quit", "q":
+			return
+		case "back", "b", "..":
+			if len(history) > 0 {
+				pos = history[len(history)-1]
+				history = history[:len(history)-1]
+			}
+			continue
+		}
+
+		m, err := decodeMove(pos, input)
+		if err != nil {
+			fmt.Printf("Invalid move %s\n\n", input)
+			continue
+		}
+		history = append(history, pos)
+		pos = pos.Update(m)
+	}
+}
+
+// decodeMove reads a legal move in algebraic or UCI notation.
+func decodeMove(pos *chess.Position, s string) (*chess.Move, error) {
+	m, err := chess.AlgebraicNotation{}.Decode(pos, s)
+	if err == nil {
+		return m, nil
+	}
+	if m, err = (chess.UCINotation{}).Decode(pos, s); err != nil {
+		return nil, err
+	}
+
+	// UCI moves aren't checked against the position, so use the legal move,
+	// which is also tagged with whether it gives check
+	for _, valid := range pos.ValidMoves() {
+		if valid.String() == m.String() {
+			return valid, nil
+		}
+	}
+	return nil, fmt.Errorf("Illegal move %s", s)
+}
+
+// exploreMoves returns the stats of moves played from pos in games, split by
+// the color the user played. Moves are sorted by popularity.
+func exploreMoves(pos *chess.Position, games []Game, user string) (white, black []*moveStats) {
+	target := PositionHash(pos)
+	nalg := chess.AlgebraicNotation{}
+
+	byColor := [2]map[string]*moveStats{{}, {}}
+	for i := range games {
+		g := &games[i]
+		game, err := g.Game()
+		if err != nil {
+			log.WithError(err).WithField("url", g.URL).Warn("Could not parse game")
+			continue
+		}
+
+		color := 0
+		if g.Black.Username == user {
+			color = 1
+		}
+
+		positions := game.Positions()
+		for j, m := range game.Moves() {
+			if PositionHash(positions[j]) != target {
+				continue
+			}
+
+			san := nalg.Encode(positions[j], m)
+			s, ok := byColor[color][san]
+			if !ok {
+				s = &moveStats{SAN: san}
+				byColor[color][san] = s
+			}
+			s.add(*g, user)
+
+			// only count the first time a game reaches the position
+			break
+		}
+	}
+
+	sorted := func(stats map[string]*moveStats) []*moveStats {
+		var out []*moveStats
+		for _, s := range stats {
+			out = append(out, s)
+		}
+		sort.Slice(out, func(i, j int) bool {
+			if out[i].Games != out[j].Games {
+				return out[i].Games > out[j].Games
+			}
+			return out[i].SAN < out[j].SAN
+		})
+		return out
+	}
+	return sorted(byColor[0]), sorted(byColor[1])
+}
+
+func formatExplorer(pos *chess.Position, games []Game, user string) string {
+	white, black := exploreMoves(pos, games, user)
+
+	buf := &strings.Builder{}
+	fmt.Fprintln(buf, pos.String())
+	for _, side := range []struct {
+		name  string
+		moves []*moveStats
+	}{
+		{"white", white},
+		{"black", black},
+	} {
+		var total int
+		for _, s := range side.moves {
+			total += s.Games
+		}
+
+		fmt.Fprintf(buf, "\nAs %s (%d games)\n", side.name, total)
+		if total == 0 {
+			continue
+		}
+
+		fmt.Fprintf(buf, "%-8s %6s %6s %6s %6s %6s\n", "move", "games", "win", "draw", "loss", "opp")
+		for _, s := range side.moves {
+			fmt.Fprintf(buf, "%-8s %6d %5.0f%% %5.0f%% %5.0f%% %6d\n",
+				s.SAN, s.Games,
+				s.percent(s.Wins), s.percent(s.Draws), s.percent(s.Losses),
+				s.AverageOpponentRating())
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+)
+
+// Export writes the games matching the search filters as a PGN database,
+// oldest first. Games are written to the file given as the first argument,
+// or stdout if there is none. When splitting, the argument is the directory
+// to write one file per group to instead.
+func Export(cfg config) {
+	games := matchingGames(cfg)
+
+	var dest string
+	if len(cfg.args) > 0 {
+		dest = cfg.args[0]
+	}
+
+	// group games by output file, keeping the order files are first seen
+	var names []string
+	groups := make(map[string][]Game)
+	for i := len(games) - 1; i >= 0; i-- {
+		g := games[i]
+
+		var name string
+		switch cfg.split {
+		case "":
+			name = dest
+		case "month":
+			name = fmt.Sprintf("%s-%s.pgn", cfg.user, g.EndTime.Format("2006-01"))
+		case "class":
+			name = fmt.Sprintf("%s-%s.pgn", cfg.user, g.TimeClass)
+		default:
+			log.WithField("split", cfg.split).Fatal("Unknown way to split games")
+		}
+
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], g)
+	}
+
+	if cfg.split != "" && dest != "" {
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			log.WithError(err).WithField("dir", dest).
+				Fatal("Could not create export directory")
+		}
+	}
+
+	for _, name := range names {
+		path := name
+		if cfg.split != "" {
+			path = filepath.Join(dest, name)
+		}
+
+		if err := exportGames(path, groups[name]); err != nil {
+			log.WithError(err).WithField("path", path).
+				Fatal("Could not export games")
+		}
+		log.WithFields(log.Fields{
+			"path":  path,
+			"count": len(groups[name]),
+		}).Info("Exported games")
+	}
+}
+
+// exportGames writes games to the file at path, or stdout if path is empty.
+func exportGames(path string, games []Game) error {
+	if path == "" {
+		return writePGNs(os.Stdout, games)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writePGNs(f, games); err != nil {
+		f.Close()
+		return err
+	}
+	// writes may only fail once the file is flushed on close
+	return f.Close()
+}
+
+// writePGNs writes games to a PGN database.
+func writePGNs(w io.Writer, games []Game) error {
+	for _, g := range games {
+		if err := writePGN(w, g.PGN()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePGN writes a single game to a PGN database, making sure it is
+// separated from the next game by a blank line.
+func writePGN(w io.Writer, pgn string) error {
+	_, err := fmt.Fprintf(w, "%s\n\n", strings.TrimSpace(pgn))
+	return err
+}
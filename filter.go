@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/notnil/chess"
+)
+
+// Range is an inclusive range of integers. Zero bounds are open.
+type Range struct {
+	Min int
+	Max int
+}
+
+// ParseRange parses ranges of the form "min-max", where either bound may be
+// left out, e.g. "1200-", "-1400" or "1200-1400". A single number matches
+// only itself. A bound of 0 is the same as leaving it out.
+func ParseRange(s string) (Range, error) {
+	var r Range
+	if s == "" {
+		return r, nil
+	}
+
+	var err error
+	i := strings.Index(s, "-")
+	if i < 0 {
+		r.Min, err = strconv.Atoi(s)
+		r.Max = r.Min
+		return r, err
+	}
+
+	if min := s[:i]; min != "" {
+		if r.Min, err = strconv.Atoi(min); err != nil {
+			return r, err
+		}
+	}
+	if max := s[i+1:]; max != "" {
+		if r.Max, err = strconv.Atoi(max); err != nil {
+			return r, err
+		}
+	}
+	if r.Min != 0 && r.Max != 0 && r.Min > r.Max {
+		return r, fmt.Errorf("minimum %d is greater than maximum %d", r.Min, r.Max)
+	}
+	return r, nil
+}
+
+func (r Range) Contains(n int) bool {
+	return (r.Min == 0 || n >= r.Min) && (r.Max == 0 || n <= r.Max)
+}
+
+// Filter selects games from the perspective of the user who played them.
+// Zero values match everything.
+type Filter struct {
+	User string
+
+	Color       string
+	Result      string
+	Termination Termination
+	TimeClasses []string
+	// time controls as "3+2" or in Chess.com's format, "180+2"
+	TimeControls []string
+	// "true" or "false" to match only rated or unrated games
+	Rated          string
+	Since          time.Time
+	Until          time.Time
+	Opponent       string
+	Rating         Range
+	OpponentRating Range
+	// game length in full moves
+	Moves Range
+	// initial moves of the game
+	Opening []*chess.Move
+	// ECO code, range of codes or part of the opening name
+	ECO string
+	// hash of a position the game must pass through, and the IDs of the
+	// games that do, which are looked up once the games are loaded
+	Position      uint64
+	positionGames map[string]bool
+	// position described by -q or -fen, if any
+	Board *chess.Position
+}
+
+// filterFlags are the raw values of the filter flags.
+type filterFlags struct {
+	color, result, termination, class, rated, since, until string
+	opponent, rating, opponentRating, moves, query         string
+	fen, eco, timeControl                                  string
+	position                                               bool
+}
+
+// newFilter validates the filter flags.
+func newFilter(user string, ff filterFlags) (Filter, error) {
+	f := Filter{
+		User:     user,
+		Color:    strings.ToLower(ff.color),
+		Result:   strings.ToLower(ff.result),
+		Rated:    ff.rated,
+		Opponent: ff.opponent,
+		ECO:      ff.eco,
+	}
+
+	switch f.Color {
+	case "", "white", "black":
+	default:
+		return f, fmt.Errorf("Unknown color %s", ff.color)
+	}
+
+	switch f.Result {
+	case "", "win", "draw", "abandoned":
+	case "loss", "lose":
+		f.Result = "lose"
+	default:
+		return f, fmt.Errorf("Unknown result %s", ff.result)
+	}
+
+	var err error
+	if ff.termination != "" {
+		if f.Termination, err = ParseTermination(ff.termination); err != nil {
+			return f, err
+		}
+		// every decisive game has a win and a loss, so these would only
+		// filter out draws
+		if f.Termination == Won || f.Termination == Lost {
+			return f, fmt.Errorf("Termination %s matches every decisive game, use -result instead", ff.termination)
+		}
+	}
+
+	if ff.class != "" {
+		f.TimeClasses = strings.Split(strings.ToLower(ff.class), ",")
+	}
+	if ff.timeControl != "" {
+		f.TimeControls = strings.Split(ff.timeControl, ",")
+	}
+
+	switch f.Rated {
+	case "", "true", "false":
+	default:
+		return f, fmt.Errorf("Rated must be true or false, not %s", ff.rated)
+	}
+
+	if ff.since != "" {
+		if f.Since, err = time.ParseInLocation("2006-01-02", ff.since, time.Local); err != nil {
+			return f, err
+		}
+	}
+	if ff.until != "" {
+		if f.Until, err = time.ParseInLocation("2006-01-02", ff.until, time.Local); err != nil {
+			return f, err
+		}
+		// include the whole day
+		f.Until = f.Until.AddDate(0, 0, 1)
+	}
+
+	if f.Rating, err = ParseRange(ff.rating); err != nil {
+		return f, fmt.Errorf("Invalid rating range %s: %w", ff.rating, err)
+	}
+	if f.OpponentRating, err = ParseRange(ff.opponentRating); err != nil {
+		return f, fmt.Errorf("Invalid opponent rating range %s: %w", ff.opponentRating, err)
+	}
+	if f.Moves, err = ParseRange(ff.moves); err != nil {
+		return f, fmt.Errorf("Invalid move range %s: %w", ff.moves, err)
+	}
+
+	if ff.position && ff.query == "" {
+		return f, fmt.Errorf("-p needs the moves to reach the position in -q")
+	}
+
+	// validate moves in query string
+	if ff.query != "" {
+		searchBoard := chess.NewGame()
+		for _, m := range strings.Split(ff.query, " ") {
+			if err := searchBoard.MoveStr(m); err != nil {
+				return f, fmt.Errorf("Invalid move %s in query string: %w", m, err)
+			}
+		}
+		f.Board = searchBoard.Position()
+		if ff.position {
+			f.Position = PositionHash(searchBoard.Position())
+		} else {
+			f.Opening = searchBoard.Moves()
+		}
+	}
+
+	if ff.fen != "" {
+		fen, err := chess.FEN(ff.fen)
+		if err != nil {
+			return f, fmt.Errorf("Invalid FEN %s: %w", ff.fen, err)
+		}
+		f.Board = chess.NewGame(fen).Position()
+		f.Position = PositionHash(f.Board)
+	}
+
+	return f, nil
+}
+
+// Match returns whether the game passes every filter. g is a pointer so the
+// moves parsed for the last filters are kept for the caller.
+func (f Filter) Match(g *Game) bool {
+	var player, opponent Player
+	var color string
+	switch f.User {
+	case g.White.Username:
+		player, opponent, color = g.White, g.Black, "white"
+	case g.Black.Username:
+		player, opponent, color = g.Black, g.White, "black"
+	}
+
+	if f.Color != "" && f.Color != color {
+		return false
+	}
+	if f.Result != "" && f.Result != player.NormalizedResult() {
+		return false
+	}
+	if f.Termination != UnknownTermination &&
+		f.Termination != g.White.Termination && f.Termination != g.Black.Termination {
+		return false
+	}
+	if f.TimeClasses != nil && !containsString(f.TimeClasses, g.TimeClass) {
+		return false
+	}
+	if f.TimeControls != nil && !matchTimeControl(g.TimeControl, f.TimeControls) {
+		return false
+	}
+	if f.Rated != "" && f.Rated != strconv.FormatBool(g.Rated) {
+		return false
+	}
+	if !f.Since.IsZero() && g.EndTime.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !g.EndTime.Before(f.Until) {
+		return false
+	}
+	if f.Opponent != "" && !strings.EqualFold(f.Opponent, opponent.Username) {
+		return false
+	}
+	if !f.Rating.Contains(player.Rating) || !f.OpponentRating.Contains(opponent.Rating) {
+		return false
+	}
+	if f.Position != 0 && !f.positionGames[g.ID()] {
+		return false
+	}
+
+	// the remaining filters need the moves
+	if f.Moves == (Range{}) && f.Opening == nil && f.ECO == "" {
+		return true
+	}
+
+	game, err := g.Game()
+	if err != nil {
+		log.WithError(err).WithField("url", g.URL).Warn("Could not parse game")
+		return false
+	}
+
+	if !f.Moves.Contains((len(game.Moves()) + 1) / 2) {
+		return false
+	}
+	if f.Opening != nil && !movesMatch(game.Moves(), f.Opening) {
+		return false
+	}
+	if f.ECO != "" && !matchOpening(g.Opening(), f.ECO) {
+		return false
+	}
+
+	return true
+}
+
+func movesMatch(gameMoves []*chess.Move, searchMoves []*chess.Move) bool {
+	if len(gameMoves) < len(searchMoves) {
+		return false
+	}
+
+	for i := range searchMoves {
+		if gameMoves[i].String() != searchMoves[i].String() {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package main
+
+import "testing"
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Range
+		err  bool
+	}{
+		{s: "", want: Range{}},
+		{s: "1200-1400", want: Range{1200, 1400}},
+		{s: "1200-", want: Range{Min: 1200}},
+		{s: "-1400", want: Range{Max: 1400}},
+		{s: "1500", want: Range{1500, 1500}},
+		{s: "-", want: Range{}},
+		{s: "abc", err: true},
+		{s: "1200-abc", err: true},
+		{s: "abc-1400", err: true},
+		{s: "1400-1200", err: true},
+		{s: "0-1200", want: Range{Max: 1200}},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRange(tt.s)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseRange(%q) error = %v, want error %v", tt.s, err, tt.err)
+			continue
+		}
+		if !tt.err && got != tt.want {
+			t.Errorf("ParseRange(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestRangeContains(t *testing.T) {
+	tests := []struct {
+		r    Range
+		n    int
+		want bool
+	}{
+		{Range{}, 0, true},
+		{Range{}, 3000, true},
+		{Range{1200, 1400}, 1200, true},
+		{Range{1200, 1400}, 1400, true},
+		{Range{1200, 1400}, 1199, false},
+		{Range{1200, 1400}, 1401, false},
+		{Range{Min: 1200}, 2800, true},
+		{Range{Min: 1200}, 800, false},
+		{Range{Max: 1400}, 100, true},
+		{Range{Max: 1400}, 1500, false},
+		{Range{40, 40}, 40, true},
+		{Range{40, 40}, 41, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.r.Contains(tt.n); got != tt.want {
+			t.Errorf("%+v.Contains(%d) = %v, want %v", tt.r, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestNewFilterErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		ff   filterFlags
+	}{
+		{"position without query", filterFlags{position: true}},
+		{"inverted range", filterFlags{rating: "1400-1200"}},
+		{"win termination", filterFlags{termination: "win"}},
+		{"lose termination", filterFlags{termination: "lose"}},
+	}
+
+	for _, tt := range tests {
+		if _, err := newFilter("echojc", tt.ff); err == nil {
+			t.Errorf("%s: newFilter(%+v) succeeded, want error", tt.name, tt.ff)
+		}
+	}
+
+	if _, err := newFilter("echojc", filterFlags{position: true, query: "d4 d5"}); err != nil {
+		t.Errorf("newFilter with -p and -q failed: %v", err)
+	}
+}
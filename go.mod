@@ -3,7 +3,7 @@ module github.com/echojc/chess
 go 1.16
 
 require (
-	github.com/apex/log v1.9.0 // indirect
-	github.com/notnil/chess v1.5.0 // indirect
+	github.com/apex/log v1.9.0
+	github.com/notnil/chess v1.5.0
 	github.com/pkg/errors v0.9.1 // indirect
 )
@@ -3,12 +3,10 @@ package main
 import (
 	"flag"
 	"fmt"
-	"math"
 	"net/url"
 	"os"
 	"strings"
 	"time"
-	"unicode"
 
 	"github.com/apex/log"
 	"github.com/apex/log/handlers/text"
@@ -20,40 +18,82 @@ func init() {
 }
 
 type config struct {
-	user   string
-	output string
+	user    string
+	output  string
+	command string
+	args    []string
 
 	// data consistency
 	cacheOnly  bool
 	forceFetch bool
 
 	// search
-	limit int
-	query string
+	limit  int
+	filter Filter
 
 	// analyse
-	analyze   string
-	depth     int
-	timeout   time.Duration
-	threshold float64
+	analyze string
+	engine  string
+	compare string
+	depth   int
+	timeout time.Duration
+
+	// export
+	split string
+
+	// explore
+	interactive bool
+
+	// stats
+	group    string
+	minGames int
+
+	// opponents
+	last int
 }
 
 func main() {
 	var (
 		logLevelString = flag.String("l", "info", "Log level.")
 		user           = flag.String("u", "", "User whose games to load. (required)")
-		output         = flag.String("o", "", "Output format: pgn (default), url")
+		output         = flag.String("o", "", "Output format: pgn (default), url, json, jsonl, csv")
 
 		isRefresh = flag.Bool("r", false, "Check server for new data for user.")
 		isForce   = flag.Bool("f", false, "Force refresh all data for user.")
 
 		limit = flag.Int("n", 20, "Number of games to display.")
 		query = flag.String("q", "", "Only display games with these initial moves (space-separated algebraic notation).")
-
-		analyze   = flag.String("a", "", "ID of game to analyse.")
-		depth     = flag.Int("d", 20, "Depth to analyse each position.")
-		timeout   = flag.Duration("t", 3*time.Second, "Timeout when analysing each position.")
-		threshold = flag.Float64("th", 1.8, "Threshold for annotating inaccurate moves (delta in position score).")
+		fen   = flag.String("fen", "", "Only display games that reached this position.")
+		isPos = flag.Bool("p", false, "Match the position reached by the moves in -q, in any move order.")
+
+		color          = flag.String("color", "", "Only display games played as: white, black")
+		result         = flag.String("result", "", "Only display games with result: win, draw, loss")
+		termination    = flag.String("term", "", "Only display games ending by: checkmated, resigned, timeout, timevsinsufficient, repetition, agreed, stalemate, 50move, insufficient, abandoned, kingofthehill, threecheck, bughousepartnerlose")
+		class          = flag.String("class", "", "Only display games with these time classes (comma-separated): bullet, blitz, rapid, daily")
+		timeControl    = flag.String("tc", "", "Only display games with these time controls (comma-separated), as minutes+increment (3+2) or seconds+increment (180+2).")
+		rated          = flag.String("rated", "", "Only display rated (true) or unrated (false) games.")
+		since          = flag.String("since", "", "Only display games played on or after this date (YYYY-MM-DD).")
+		until          = flag.String("until", "", "Only display games played on or before this date (YYYY-MM-DD).")
+		opponent       = flag.String("opp", "", "Only display games against this opponent.")
+		rating         = flag.String("rating", "", "Only display games where user's rating was in this range (min-max, 0 means unbounded).")
+		opponentRating = flag.String("opp-rating", "", "Only display games where opponent's rating was in this range (min-max, 0 means unbounded).")
+		moves          = flag.String("moves", "", "Only display games lasting this many moves (min-max, 0 means unbounded).")
+		eco            = flag.String("eco", "", "Only display games in this opening: ECO code (B2), range (B20-B99) or part of its name.")
+
+		analyze = flag.String("a", "", "ID of game to analyse, latest, or all to analyse every matching game.")
+		engine  = flag.String("e", defaultEngine, "Engine to analyse with, as name[=path].")
+		compare = flag.String("c", "", "Second engine to compare analysis against, as name[=path].")
+		depth   = flag.Int("d", 20, "Depth to analyse each position.")
+		timeout = flag.Duration("t", 3*time.Second, "Timeout when analysing each position.")
+
+		split = flag.String("s", "", "Split exported games into files by: month, class")
+
+		interactive = flag.Bool("i", false, "Explore interactively, entering moves to follow.")
+
+		group    = flag.String("g", "name", "Group opening statistics by: name, eco, or a number of initial moves.")
+		minGames = flag.Int("min", 3, "Minimum number of games for an opening to be listed in statistics.")
+
+		last = flag.Int("last", 3, "Number of recent games to list for each opponent.")
 	)
 	flag.Parse()
 
@@ -62,6 +102,12 @@ func main() {
 		os.Exit(2)
 	}
 
+	switch *output {
+	case "", "pgn", "url", outputJSON, outputJSONL, outputCSV:
+	default:
+		log.WithField("output", *output).Fatal("Unknown output format")
+	}
+
 	logLevel, err := log.ParseLevel(*logLevelString)
 	if err != nil {
 		log.WithField("level", *logLevelString).
@@ -70,18 +116,50 @@ func main() {
 	}
 	log.SetLevel(logLevel)
 
+	filter, err := newFilter(*user, filterFlags{
+		color:          *color,
+		result:         *result,
+		termination:    *termination,
+		class:          *class,
+		timeControl:    *timeControl,
+		rated:          *rated,
+		since:          *since,
+		until:          *until,
+		opponent:       *opponent,
+		rating:         *rating,
+		opponentRating: *opponentRating,
+		moves:          *moves,
+		query:          *query,
+		fen:            *fen,
+		eco:            *eco,
+		position:       *isPos,
+	})
+	if err != nil {
+		log.WithError(err).Fatal("Invalid filter")
+	}
+
 	// read arguments into config
 	cfg := config{
-		user:       *user,
-		output:     *output,
-		cacheOnly:  !*isRefresh,
-		forceFetch: *isForce,
-		limit:      *limit,
-		query:      *query,
-		analyze:    *analyze,
-		depth:      *depth,
-		timeout:    *timeout,
-		threshold:  *threshold,
+		user:        *user,
+		output:      *output,
+		cacheOnly:   !*isRefresh,
+		forceFetch:  *isForce,
+		limit:       *limit,
+		filter:      filter,
+		analyze:     *analyze,
+		engine:      *engine,
+		compare:     *compare,
+		depth:       *depth,
+		timeout:     *timeout,
+		split:       *split,
+		interactive: *interactive,
+		group:       *group,
+		minGames:    *minGames,
+		last:        *last,
+		command:     flag.Arg(0),
+	}
+	if flag.NArg() > 1 {
+		cfg.args = flag.Args()[1:]
 	}
 	log.WithField("cfg", cfg).Debug("Loaded arguments")
 
@@ -95,73 +173,180 @@ func main() {
 	}
 
 	// main function
-	if cfg.analyze != "" {
-		Analyze(cfg)
-	} else {
-		Search(cfg)
+	switch cfg.command {
+	case "export":
+		Export(cfg)
+	case "explore":
+		Explore(cfg)
+	case "stats":
+		Stats(cfg)
+	case "repertoire":
+		CheckRepertoire(cfg)
+	case "opponents":
+		Opponents(cfg)
+	case "vs":
+		Versus(cfg)
+	case "puzzles":
+		Puzzles(cfg)
+	case "train":
+		Train(cfg)
+	case "":
+		if cfg.analyze != "" && cfg.compare != "" {
+			Compare(cfg)
+		} else if cfg.analyze != "" {
+			Analyze(cfg)
+		} else {
+			Search(cfg)
+		}
+	default:
+		log.WithField("command", cfg.command).Fatal("Unknown command")
 	}
 }
 
 func Analyze(cfg config) {
-	var data Game
-	var err error
+	games := gamesToAnalyze(cfg)
+
+	e, err := NewEngine(cfg.engine, cfg.depth, cfg.timeout)
+	if err != nil {
+		log.WithError(err).WithField("engine", cfg.engine).
+			Fatal("Could not initialise analysis engine")
+	}
+	defer e.Close()
+
+	// analyses are kept so statistics can include accuracy
+	analyses := LoadAnalyses(cfg.user)
 
-	if cfg.analyze == "latest" {
-		games, err := ListCachedGames(cfg.user)
+	var records []AnalysisRecord
+	for i, data := range games {
+		g, err := data.Game()
 		if err != nil {
-			log.WithError(err).WithField("user", cfg.user).
-				Fatal("Could not get games")
+			log.WithError(err).WithField("url", data.URL.String()).
+				Warn("Could not parse game to analyse")
+			continue
 		}
-		data = games[0]
-	} else {
-		data, err = OpenGame(cfg.user, cfg.analyze)
+
+		// evaluate all board positions
+		positions := g.Positions()
+		log.WithFields(log.Fields{
+			"url":     data.URL.String(),
+			"game":    fmt.Sprintf("%d/%d", i+1, len(games)),
+			"count":   len(positions),
+			"engine":  e.Name(),
+			"depth":   cfg.depth,
+			"timeout": cfg.timeout,
+		}).Info("Starting analysis")
+		results := analyzePositions(e, positions)
+
+		plies := assessMoves(g, results)
+		addClocks(plies, &data)
+		summaries := summarize(data, plies)
+
+		record := newAnalysisRecord(data, cfg.user, e.Name(), cfg.depth, plies, summaries)
+		analyses[data.ID()] = record
+		SaveAnalyses(cfg.user, analyses)
+
+		if isDataOutput(cfg.output) {
+			records = append(records, record)
+			continue
+		}
+
+		pgn := annotatedPGN(g, plies, summaries, e.Name())
+		if err := validatePGN(pgn, g); err != nil {
+			log.WithError(err).WithField("url", data.URL.String()).
+				Warn("Annotated PGN could not be read back")
+		}
+
+		switch cfg.output {
+		case "url":
+			fmt.Printf("https://chess.com/analysis?pgn=%s\n", url.QueryEscape(pgn))
+		default:
+			fmt.Print(pgn)
+		}
+
+		fmt.Println()
+		fmt.Print(formatSummaries(summaries, plies))
+		fmt.Println()
+		fmt.Print(formatCriticalMoments(criticalMoments(plies), plies))
+		if i < len(games)-1 {
+			fmt.Println()
+		}
+	}
+
+	if isDataOutput(cfg.output) {
+		if err := writeAnalyses(os.Stdout, cfg.output, records, cfg.analyze == "all"); err != nil {
+			// deferred calls don't run on Fatal
+			e.Close()
+			log.WithError(err).Fatal("Could not write analysis")
+		}
+		return
+	}
+
+	// totals by phase across a batch
+	if len(games) > 1 {
+		fmt.Println()
+		fmt.Print(formatPhaseStats(phaseStats(games, cfg.user, analyses)))
+	}
+}
+
+// gamesToAnalyze returns the games selected by -a: a single game by ID, the
+// latest game matching the filters, or all of them up to the limit.
+func gamesToAnalyze(cfg config) []Game {
+	switch cfg.analyze {
+	case "all", "latest":
+		games := matchingGames(cfg)
+		if len(games) == 0 {
+			log.WithField("user", cfg.user).Fatal("No games to analyse")
+		}
+
+		limit := cfg.limit
+		if cfg.analyze == "latest" {
+			limit = 1
+		}
+		if len(games) > limit {
+			games = games[:limit]
+		}
+		return games
+	default:
+		data, err := OpenGame(cfg.user, cfg.analyze)
 		if err != nil {
 			log.WithError(err).WithFields(log.Fields{
 				"user": cfg.user,
 				"id":   cfg.analyze,
 			}).Fatal("Could not find game to analyse")
 		}
+		return []Game{data}
 	}
+}
 
-	g, err := data.Game()
-	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"user": cfg.user,
-			"id":   cfg.analyze,
-		}).Fatal("Could not parse game to analyse")
-	}
-
-	e, err := NewEngine(cfg.depth, cfg.timeout)
-	if err != nil {
-		log.WithError(err).Fatal("Could not initialise analysis engine")
-	}
-
-	// evaluate all board positions
-	positions := g.Positions()
-	log.WithFields(log.Fields{
-		"url":       data.URL.String(),
-		"count":     len(positions),
-		"depth":     cfg.depth,
-		"timeout":   cfg.timeout,
-		"threshold": cfg.threshold,
-	}).Info("Starting analysis")
+// analyzePositions evaluates each position with a. Scores in the returned
+// results are always from white's perspective, and positions that couldn't
+// be analysed have Err set.
+func analyzePositions(a Analyzer, positions []*chess.Position) []Result {
 	var results = make([]Result, len(positions))
 	for i, p := range positions {
 		fen := p.String()
 
-		r := e.Analyze(fen)
-		if err = e.Err(); err != nil {
-			log.WithError(err).WithFields(log.Fields{"i": i, "fen": fen}).
-				Warn("Could not analyse board state")
+		r := a.Analyze(fen)
+		if err := a.Err(); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"engine": a.Name(),
+				"i":      i,
+				"fen":    fen,
+			}).Warn("Could not analyse board state")
+			results[i] = Result{Err: err}
 			continue
 		}
 
-		// engine returns score from current player's perspective
-		if i%2 == 1 {
-			r.Score *= -1
+		// engine returns score from current player's perspective, subtract
+		// from zero so an even position isn't written as -0
+		if p.Turn() == chess.Black {
+			r.Score = 0 - r.Score
+			r.Mate *= -1
+			r.WDL[0], r.WDL[2] = r.WDL[2], r.WDL[0]
 		}
 		if r.Err != nil {
 			log.WithError(r.Err).WithFields(log.Fields{
+				"engine":   a.Name(),
 				"i":        i,
 				"fen":      fen,
 				"score":    r.Score,
@@ -170,120 +355,70 @@ func Analyze(cfg config) {
 		}
 
 		results[i] = r
-		log.WithFields(log.Fields{"i": i, "t": r.Time, "d": r.Depth}).
-			Info("Analysed position")
+		log.WithFields(log.Fields{
+			"engine": a.Name(),
+			"i":      i,
+			"t":      r.Time,
+			"d":      r.Depth,
+		}).Info("Analysed position")
 	}
 
-	nalg := chess.AlgebraicNotation{}
-	nuci := chess.UCINotation{}
-
-	buf := &strings.Builder{}
-	for i, gameMove := range g.Moves() {
-		var turn string
-		if i%2 == 0 {
-			turn = fmt.Sprintf("%d.", i/2+1)
-		} else {
-			turn = fmt.Sprintf("%d...", i/2+1)
-		}
+	return results
+}
 
-		gameMoveStr := nalg.Encode(positions[i], gameMove)
-		fmt.Fprintf(buf, "%s %s ", turn, gameMoveStr)
+func Search(cfg config) {
+	games := matchingGames(cfg)
 
-		var bestMoveStr = results[i].BestMove
-		bestMove, err := nuci.Decode(positions[i], bestMoveStr)
-		if err != nil {
-			log.WithError(err).WithField("move", bestMove).
-				Warn("Could not decode best move")
+	var records []GameRecord
+	for i := 0; i < len(games) && i < cfg.limit; i++ {
+		if isDataOutput(cfg.output) {
+			records = append(records, newGameRecord(games[i], cfg.user))
 		} else {
-			bestMoveStr = nalg.Encode(positions[i], bestMove)
+			fmt.Println(formatGame(games[i], cfg.user))
 		}
-
-		if gameMoveStr == bestMoveStr {
-			fmt.Fprint(buf, "{★} ")
-		}
-
-		delta := results[i+1].Score - results[i].Score
-		if math.Abs(delta) > cfg.threshold {
-			fmt.Fprintf(buf, "{ %+.2f } (%s %s) ", delta, turn, bestMoveStr)
-		}
-	}
-
-	switch cfg.output {
-	case "url":
-		fmt.Printf("https://chess.com/analysis?pgn=%s\n", url.QueryEscape(buf.String()))
-	default:
-		fmt.Println(buf.String())
 	}
-}
 
-func Search(cfg config) {
-	// validate moves in query string
-	var searchMoves []*chess.Move
-	if cfg.query != "" {
-		algebraicMoves := strings.Split(cfg.query, " ")
-		searchBoard := chess.NewGame()
-		for _, m := range algebraicMoves {
-			err := searchBoard.MoveStr(m)
-			if err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"q":    cfg.query,
-					"move": m,
-				}).Fatal("Invalid move in query string")
-			}
+	if isDataOutput(cfg.output) {
+		if err := writeRecords(os.Stdout, cfg.output, gameCSVHeader, records); err != nil {
+			log.WithError(err).Fatal("Could not write games")
 		}
-		searchMoves = searchBoard.Moves()
 	}
+}
 
+// matchingGames returns all cached games that match the search filters,
+// newest first.
+func matchingGames(cfg config) []Game {
 	games, err := ListCachedGames(cfg.user)
 	if err != nil {
 		log.WithError(err).WithField("user", cfg.user).Fatal("Could not get games")
 	}
 
-	for i, n := 0, 0; i < len(games) && n < cfg.limit; i++ {
-		if searchMoves != nil && !movesMatch(games[i], searchMoves) {
-			continue
-		}
-
-		fmt.Println(formatGame(games[i], cfg.user))
-		n++
-	}
-}
-
-func movesMatch(g Game, searchMoves []*chess.Move) bool {
-	game, err := g.Game()
-	if err != nil {
-		log.WithError(err).WithField("url", g.URL).Warn("Could not parse game")
-		return false
-	}
-	gameMoves := game.Moves()
-
-	if len(gameMoves) < len(searchMoves) {
-		return false
+	filter := cfg.filter
+	if filter.Position != 0 {
+		filter.positionGames = gamesReaching(cfg.user, games, filter.Position)
 	}
 
-	for i := range searchMoves {
-		if gameMoves[i].String() != searchMoves[i].String() {
-			return false
+	var matches []Game
+	for i := range games {
+		if filter.Match(&games[i]) {
+			matches = append(matches, games[i])
 		}
 	}
 
-	return true
+	return matches
 }
 
 func formatGame(g Game, user string) string {
 	var rating int
 	var icon rune
-	var result rune
 
 	switch user {
 	case g.White.Username:
 		rating = g.White.Rating
 		icon = '♔'
-		result = unicode.ToUpper([]rune(g.White.NormalizedResult())[0])
 	case g.Black.Username:
 		rating = g.Black.Rating
 		icon = '♚'
-		result = unicode.ToUpper([]rune(g.Black.NormalizedResult())[0])
 	}
 
 	t := chess.NewGame()
@@ -297,12 +432,14 @@ func formatGame(g Game, user string) string {
 		log.WithError(err).WithField("url", g.URL).Warn("Could not parse game")
 	}
 
-	return fmt.Sprintf("%s [%s] %c%4d%c %s",
+	return fmt.Sprintf("%s [%s] %c%4d %-6s %s (%s) %s",
 		g.EndTime.Format("02/01"),
 		g.URL,
 		icon,
 		rating,
-		result,
+		g.TimeControl,
+		g.Describe(user),
+		g.Opening(),
 		strings.TrimSpace(t.String()),
 	)
 }
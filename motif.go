@@ -0,0 +1,528 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/notnil/chess"
+)
+
+// Motif is a tactical pattern found in an engine line.
+type Motif int
+
+const (
+	Fork Motif = iota
+	Pin
+	Skewer
+	DiscoveredAttack
+	HangingPiece
+	MateThreat
+	BackRankMate
+	Mate
+)
+
+// motifs lists every motif, for reporting them in a fixed order.
+var motifs = []Motif{
+	Fork, Pin, Skewer, DiscoveredAttack, HangingPiece, MateThreat, BackRankMate, Mate,
+}
+
+func (m Motif) String() string {
+	switch m {
+	case Fork:
+		return "fork"
+	case Pin:
+		return "pin"
+	case Skewer:
+		return "skewer"
+	case DiscoveredAttack:
+		return "discovered attack"
+	case HangingPiece:
+		return "hanging piece"
+	case MateThreat:
+		return "mate threat"
+	case BackRankMate:
+		return "back-rank mate"
+	default:
+		return "mate"
+	}
+}
+
+// motifStrings returns the names of the motifs, an empty list if there are
+// none.
+func motifStrings(ms []Motif) []string {
+	s := []string{}
+	for _, m := range ms {
+		s = append(s, m.String())
+	}
+	return s
+}
+
+// motifMoves is how many of the attacking side's moves at the start of a
+// line are checked for motifs. Later moves are too far from the position to
+// say what it was about.
+const motifMoves = 2
+
+// pieceValues are the usual material values, with the king worth more than
+// anything it could be traded for.
+var pieceValues = map[chess.PieceType]int{
+	chess.Pawn:   1,
+	chess.Knight: 3,
+	chess.Bishop: 3,
+	chess.Rook:   5,
+	chess.Queen:  9,
+	chess.King:   100,
+}
+
+var (
+	knightSteps   = [][2]int{{1, 2}, {2, 1}, {2, -1}, {1, -2}, {-1, -2}, {-2, -1}, {-2, 1}, {-1, 2}}
+	diagonalSteps = [][2]int{{1, 1}, {1, -1}, {-1, -1}, {-1, 1}}
+	straightSteps = [][2]int{{1, 0}, {0, 1}, {-1, 0}, {0, -1}}
+	kingSteps     = append(append([][2]int{}, diagonalSteps...), straightSteps...)
+)
+
+// offset returns the square df files and dr ranks away from sq, if it's on
+// the board.
+func offset(sq chess.Square, df, dr int) (chess.Square, bool) {
+	f, r := int(sq.File())+df, int(sq.Rank())+dr
+	if f < 0 || f > 7 || r < 0 || r > 7 {
+		return chess.NoSquare, false
+	}
+	return chess.Square(r*8 + f), true
+}
+
+// slides returns the directions a piece moves in any number of steps.
+func slides(t chess.PieceType) [][2]int {
+	switch t {
+	case chess.Bishop:
+		return diagonalSteps
+	case chess.Rook:
+		return straightSteps
+	case chess.Queen:
+		return kingSteps
+	}
+	return nil
+}
+
+// attacks returns the squares attacked by the piece on sq.
+func attacks(b *chess.Board, sq chess.Square) []chess.Square {
+	p := b.Piece(sq)
+	var steps [][2]int
+	switch p.Type() {
+	case chess.Pawn:
+		dr := 1
+		if p.Color() == chess.Black {
+			dr = -1
+		}
+		steps = [][2]int{{-1, dr}, {1, dr}}
+	case chess.Knight:
+		steps = knightSteps
+	case chess.King:
+		steps = kingSteps
+	}
+
+	var squares []chess.Square
+	for _, s := range steps {
+		if to, ok := offset(sq, s[0], s[1]); ok {
+			squares = append(squares, to)
+		}
+	}
+	for _, s := range slides(p.Type()) {
+		for to, ok := offset(sq, s[0], s[1]); ok; to, ok = offset(to, s[0], s[1]) {
+			squares = append(squares, to)
+			if b.Piece(to) != chess.NoPiece {
+				break
+			}
+		}
+	}
+	return squares
+}
+
+// between returns whether sq lies on the straight or diagonal line strictly
+// between from and to.
+func between(from, to, sq chess.Square) bool {
+	df, dr := int(to.File())-int(from.File()), int(to.Rank())-int(from.Rank())
+	if df != 0 && dr != 0 && df != dr && df != -dr {
+		return false
+	}
+	df, dr = sign(df), sign(dr)
+	for s, ok := offset(from, df, dr); ok && s != to; s, ok = offset(s, df, dr) {
+		if s == sq {
+			return true
+		}
+	}
+	return false
+}
+
+func sign(n int) int {
+	switch {
+	case n > 0:
+		return 1
+	case n < 0:
+		return -1
+	}
+	return 0
+}
+
+// defended returns whether any piece of color c attacks sq.
+func defended(b *chess.Board, sq chess.Square, c chess.Color) bool {
+	for from, p := range b.SquareMap() {
+		if p.Color() != c || from == sq {
+			continue
+		}
+		for _, to := range attacks(b, from) {
+			if to == sq {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// targets returns the enemy pieces attacked by the piece on sq that are
+// worth attacking: the king, pieces worth more than the attacker, and
+// undefended pieces other than pawns.
+func targets(b *chess.Board, sq chess.Square) []chess.Square {
+	attacker := b.Piece(sq)
+	var result []chess.Square
+	for _, to := range attacks(b, sq) {
+		p := b.Piece(to)
+		if p == chess.NoPiece || p.Color() == attacker.Color() {
+			continue
+		}
+		if p.Type() == chess.King || pieceValues[p.Type()] > pieceValues[attacker.Type()] ||
+			(p.Type() != chess.Pawn && !defended(b, to, p.Color())) {
+			result = append(result, to)
+		}
+	}
+	return result
+}
+
+// xrays returns the pairs of enemy pieces lined up behind each other in the
+// directions the piece on sq slides.
+func xrays(b *chess.Board, sq chess.Square) [][2]chess.Piece {
+	attacker := b.Piece(sq)
+	var pairs [][2]chess.Piece
+	for _, s := range slides(attacker.Type()) {
+		var found []chess.Piece
+		for to, ok := offset(sq, s[0], s[1]); ok && len(found) < 2; to, ok = offset(to, s[0], s[1]) {
+			if p := b.Piece(to); p != chess.NoPiece {
+				found = append(found, p)
+			}
+		}
+		if len(found) == 2 && found[0].Color() != attacker.Color() &&
+			found[1].Color() != attacker.Color() {
+			pairs = append(pairs, [2]chess.Piece{found[0], found[1]})
+		}
+	}
+	return pairs
+}
+
+// moveMotifs returns the motifs created by the move m played in pos.
+func moveMotifs(pos *chess.Position, m *chess.Move) []Motif {
+	var found []Motif
+	before := pos.Board()
+	after := pos.Update(m).Board()
+	mover := before.Piece(m.S1())
+
+	// taking a piece that was left undefended
+	if captured := before.Piece(m.S2()); captured != chess.NoPiece &&
+		captured.Type() != chess.Pawn && !defended(before, m.S2(), captured.Color()) {
+		found = append(found, HangingPiece)
+	}
+
+	if len(targets(after, m.S2())) >= 2 {
+		found = append(found, Fork)
+	}
+
+	for _, pair := range xrays(after, m.S2()) {
+		front, back := pieceValues[pair[0].Type()], pieceValues[pair[1].Type()]
+		switch {
+		case front < back && pair[0].Type() != chess.Pawn:
+			found = append(found, Pin)
+		case front > back && pair[1].Type() != chess.Pawn:
+			found = append(found, Skewer)
+		}
+	}
+
+	// a piece behind the one that moved now attacks something worth having
+	// that wasn't already attacked. The rook moving out of the way when
+	// castling doesn't count.
+	castling := m.HasTag(chess.KingSideCastle) || m.HasTag(chess.QueenSideCastle)
+	for sq, p := range after.SquareMap() {
+		if castling {
+			break
+		}
+		if p.Color() != mover.Color() || sq == m.S2() || slides(p.Type()) == nil {
+			continue
+		}
+		var discovered bool
+		for _, to := range targets(after, sq) {
+			if between(sq, to, m.S1()) && !defended(before, to, mover.Color()) {
+				discovered = true
+			}
+		}
+		if discovered {
+			found = append(found, DiscoveredAttack)
+			break
+		}
+	}
+
+	return found
+}
+
+// threatensMate returns whether the side that just moved into pos would have
+// mate in one if it could move again.
+func threatensMate(pos *chess.Position) bool {
+	if pos.Status() != chess.NoMethod {
+		return false
+	}
+	fields := strings.Fields(pos.String())
+	if len(fields) != 6 {
+		return false
+	}
+
+	// pass the move back, which isn't legal when giving check
+	fields[1] = "w"
+	if pos.Turn() == chess.White {
+		fields[1] = "b"
+	}
+	fields[3] = "-"
+	fen, err := chess.FEN(strings.Join(fields, " "))
+	if err != nil {
+		return false
+	}
+	passed := chess.NewGame(fen).Position()
+	if inCheck(passed.Board(), pos.Turn()) {
+		return false
+	}
+
+	for _, m := range passed.ValidMoves() {
+		if passed.Update(m).Status() == chess.Checkmate {
+			return true
+		}
+	}
+	return false
+}
+
+// inCheck returns whether the king of color c is attacked.
+func inCheck(b *chess.Board, c chess.Color) bool {
+	for sq, p := range b.SquareMap() {
+		if p.Type() == chess.King && p.Color() == c {
+			return defended(b, sq, c.Other())
+		}
+	}
+	return false
+}
+
+// mateMotif returns whether the position is mate, and whether it's mate on
+// the back rank by a rook or queen.
+func mateMotif(pos *chess.Position) (Motif, bool) {
+	if pos.Status() != chess.Checkmate {
+		return Mate, false
+	}
+
+	b := pos.Board()
+	backRank := chess.Rank1
+	if pos.Turn() == chess.Black {
+		backRank = chess.Rank8
+	}
+	for sq, p := range b.SquareMap() {
+		if p.Type() != chess.King || p.Color() != pos.Turn() || sq.Rank() != backRank {
+			continue
+		}
+		for from, q := range b.SquareMap() {
+			if q.Color() == p.Color() || from.Rank() != backRank ||
+				(q.Type() != chess.Rook && q.Type() != chess.Queen) {
+				continue
+			}
+			for _, to := range attacks(b, from) {
+				if to == sq {
+					return BackRankMate, true
+				}
+			}
+		}
+	}
+	return Mate, true
+}
+
+// lineMotifs returns the motifs in a line of moves played from pos, from the
+// point of view of the side to move in pos.
+func lineMotifs(pos *chess.Position, line []*chess.Move) []Motif {
+	seen := make(map[Motif]bool)
+	var found []Motif
+	add := func(ms ...Motif) {
+		for _, m := range ms {
+			if !seen[m] {
+				seen[m] = true
+				found = append(found, m)
+			}
+		}
+	}
+
+	mated := false
+	for i, m := range line {
+		next := pos.Update(m)
+		if i%2 == 0 && i/2 < motifMoves {
+			add(moveMotifs(pos, m)...)
+			if i == 0 && !m.HasTag(chess.Check) && threatensMate(next) {
+				add(MateThreat)
+			}
+		}
+		if motif, ok := mateMotif(next); ok {
+			// only the attacking side's mates count
+			if i%2 == 0 {
+				add(motif)
+				mated = true
+			}
+			break
+		}
+		pos = next
+	}
+
+	if mated && seen[MateThreat] {
+		// the threat was carried out
+		var rest []Motif
+		for _, m := range found {
+			if m != MateThreat {
+				rest = append(rest, m)
+			}
+		}
+		found = rest
+	}
+	return found
+}
+
+// flaggedMotifs returns the motifs in the engine's line from before a move,
+// which the move missed, and in its line from after, which the move allowed.
+// Lines are in UCI or algebraic notation.
+func flaggedMotifs(before *chess.Position, best []string, after *chess.Position, refutation []string) (missed, conceded []Motif) {
+	return lineMotifs(before, decodeLine(before, best)), lineMotifs(after, decodeLine(after, refutation))
+}
+
+// decodeLine decodes a line of moves from pos, in UCI or algebraic notation,
+// stopping at the first move that can't be decoded.
+func decodeLine(pos *chess.Position, line []string) []*chess.Move {
+	var moves []*chess.Move
+	for _, s := range line {
+		m, err := decodeMove(pos, s)
+		if err != nil {
+			break
+		}
+		moves = append(moves, m)
+		pos = pos.Update(m)
+	}
+	return moves
+}
+
+// MotifStats is how often a motif came up in the user's inaccuracies and
+// worse.
+type MotifStats struct {
+	Motif Motif
+	// times the user's move missed it, and allowed the opponent it
+	Missed   int
+	Conceded int
+}
+
+// motifStats counts the motifs tagged on the user's inaccuracies and worse in
+// the analysed games, most frequent first. It also returns how many of the
+// games had been analysed and how many moves were tagged. Moves analysed
+// before motifs were tagged aren't counted.
+func motifStats(games []Game, user string, analyses map[string]AnalysisRecord) ([]MotifStats, int, int) {
+	stats := make([]MotifStats, len(motifs))
+	byName := make(map[string]*MotifStats)
+	for i, m := range motifs {
+		stats[i].Motif = m
+		byName[m.String()] = &stats[i]
+	}
+
+	var analysed, tagged int
+	for _, g := range games {
+		a, ok := analyses[g.ID()]
+		if !ok {
+			continue
+		}
+		analysed++
+
+		color := "white"
+		if g.Black.Username == user {
+			color = "black"
+		}
+
+		for _, p := range a.Plies {
+			if p.Color != color || p.MissedMotifs == nil {
+				continue
+			}
+			tagged++
+
+			for _, m := range p.MissedMotifs {
+				if s, ok := byName[m]; ok {
+					s.Missed++
+				}
+			}
+			for _, m := range p.ConcededMotifs {
+				if s, ok := byName[m]; ok {
+					s.Conceded++
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].Missed+stats[i].Conceded > stats[j].Missed+stats[j].Conceded
+	})
+	return stats, analysed, tagged
+}
+
+func formatMotifStats(stats []MotifStats, analysed, tagged int) string {
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "%d analysed games, %d inaccuracies and worse tagged\n\n", analysed, tagged)
+	fmt.Fprintf(buf, "%-17s %6s %8s\n", "motif", "missed", "conceded")
+	for _, s := range stats {
+		fmt.Fprintf(buf, "%-17s %6d %8d\n", s.Motif, s.Missed, s.Conceded)
+	}
+	return buf.String()
+}
+
+// MotifStatsRecord is how often a motif came up in the user's inaccuracies
+// and worse, as written by stats motifs in json, jsonl and csv formats.
+type MotifStatsRecord struct {
+	Motif    string `json:"motif"`
+	Missed   int    `json:"missed"`
+	Conceded int    `json:"conceded"`
+}
+
+var motifStatsCSVHeader = []string{"motif", "missed", "conceded"}
+
+func (r MotifStatsRecord) csvRow() []string {
+	return []string{r.Motif, strconv.Itoa(r.Missed), strconv.Itoa(r.Conceded)}
+}
+
+// StatsMotifs reports which tactics the user misses or allows most often
+// across analysed games matching the filters.
+func StatsMotifs(cfg config) {
+	stats, analysed, tagged := motifStats(matchingGames(cfg), cfg.user, LoadAnalyses(cfg.user))
+	if analysed == 0 {
+		log.WithField("user", cfg.user).Fatal("No analysed games, analyse some with -a all")
+	}
+
+	if isDataOutput(cfg.output) {
+		var records []MotifStatsRecord
+		for _, s := range stats {
+			records = append(records, MotifStatsRecord{
+				Motif:    s.Motif.String(),
+				Missed:   s.Missed,
+				Conceded: s.Conceded,
+			})
+		}
+		if err := writeRecords(os.Stdout, cfg.output, motifStatsCSVHeader, records); err != nil {
+			log.WithError(err).Fatal("Could not write statistics")
+		}
+		return
+	}
+
+	fmt.Print(formatMotifStats(stats, analysed, tagged))
+}
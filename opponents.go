@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// HeadToHead is the user's record against one opponent.
+type HeadToHead struct {
+	Opponent string
+	Results  ResultStats
+	White    ResultStats
+	Black    ResultStats
+	// by time class
+	TimeClasses map[string]*ResultStats
+	// sum of the user's rating minus the opponent's, for averaging
+	ratingDiffs int
+	// games newest first
+	Games []Game
+}
+
+func (h *HeadToHead) add(g Game, user string) {
+	player, opponent := g.White, g.Black
+	if g.Black.Username == user {
+		player, opponent = g.Black, g.White
+		h.Black.add(g, user)
+	} else {
+		h.White.add(g, user)
+	}
+
+	h.Results.add(g, user)
+	if h.TimeClasses[g.TimeClass] == nil {
+		h.TimeClasses[g.TimeClass] = &ResultStats{}
+	}
+	h.TimeClasses[g.TimeClass].add(g, user)
+	h.ratingDiffs += player.Rating - opponent.Rating
+	h.Games = append(h.Games, g)
+}
+
+// RatingDiff returns the average of the user's rating minus the opponent's.
+func (h HeadToHead) RatingDiff() float64 {
+	if h.Results.Games == 0 {
+		return 0
+	}
+	return float64(h.ratingDiffs) / float64(h.Results.Games)
+}
+
+// headToHeads groups games by opponent, most played first. Usernames are
+// matched ignoring case, as on Chess.com.
+func headToHeads(games []Game, user string) []*HeadToHead {
+	byOpponent := make(map[string]*HeadToHead)
+	for _, g := range games {
+		opponent := g.White.Username
+		if opponent == user {
+			opponent = g.Black.Username
+		}
+
+		key := strings.ToLower(opponent)
+		h, ok := byOpponent[key]
+		if !ok {
+			h = &HeadToHead{
+				Opponent:    opponent,
+				TimeClasses: make(map[string]*ResultStats),
+			}
+			byOpponent[key] = h
+		}
+		h.add(g, user)
+	}
+
+	var sorted []*HeadToHead
+	for _, h := range byOpponent {
+		sorted = append(sorted, h)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Results.Games != sorted[j].Results.Games {
+			return sorted[i].Results.Games > sorted[j].Results.Games
+		}
+		return strings.ToLower(sorted[i].Opponent) < strings.ToLower(sorted[j].Opponent)
+	})
+	return sorted
+}
+
+// formatHeadToHead describes the record against an opponent, followed by up
+// to last of the most recent games.
+func formatHeadToHead(h *HeadToHead, user string, last int) string {
+	buf := &strings.Builder{}
+	r := h.Results
+	fmt.Fprintf(buf, "%s: %d games, +%d =%d -%d, score %.0f%%, rating diff %+.0f\n",
+		h.Opponent, r.Games, r.Wins, r.Draws, r.Losses, r.Score(), h.RatingDiff())
+
+	fmt.Fprintf(buf, "  %-10s %5s %5s %5s %5s %6s\n", "", "games", "win", "draw", "loss", "score")
+	row := func(name string, s ResultStats) {
+		if s.Games == 0 {
+			return
+		}
+		fmt.Fprintf(buf, "  %-10s %5d %5d %5d %5d %5.0f%%\n",
+			name, s.Games, s.Wins, s.Draws, s.Losses, s.Score())
+	}
+	row("as white", h.White)
+	row("as black", h.Black)
+
+	var classes []string
+	for class := range h.TimeClasses {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		row(class, *h.TimeClasses[class])
+	}
+
+	for i := 0; i < last && i < len(h.Games); i++ {
+		if i == 0 {
+			fmt.Fprintln(buf)
+		}
+		fmt.Fprintf(buf, "  %s\n", formatGame(h.Games[i], user))
+	}
+	return buf.String()
+}
+
+// OpponentRecord is the record against an opponent, as written by opponents
+// in json, jsonl and csv formats.
+type OpponentRecord struct {
+	Opponent   string    `json:"opponent"`
+	Games      int       `json:"games"`
+	Wins       int       `json:"wins"`
+	Draws      int       `json:"draws"`
+	Losses     int       `json:"losses"`
+	Score      float64   `json:"score"`
+	WhiteGames int       `json:"white_games"`
+	WhiteScore float64   `json:"white_score"`
+	BlackGames int       `json:"black_games"`
+	BlackScore float64   `json:"black_score"`
+	RatingDiff float64   `json:"rating_diff"`
+	LastPlayed time.Time `json:"last_played"`
+}
+
+var opponentCSVHeader = []string{
+	"opponent", "games", "wins", "draws", "losses", "score",
+	"white_games", "white_score", "black_games", "black_score",
+	"rating_diff", "last_played",
+}
+
+func (r OpponentRecord) csvRow() []string {
+	return []string{
+		r.Opponent, strconv.Itoa(r.Games),
+		strconv.Itoa(r.Wins), strconv.Itoa(r.Draws), strconv.Itoa(r.Losses),
+		strconv.FormatFloat(r.Score, 'f', 1, 64),
+		strconv.Itoa(r.WhiteGames), strconv.FormatFloat(r.WhiteScore, 'f', 1, 64),
+		strconv.Itoa(r.BlackGames), strconv.FormatFloat(r.BlackScore, 'f', 1, 64),
+		strconv.FormatFloat(r.RatingDiff, 'f', 1, 64),
+		r.LastPlayed.Format(time.RFC3339),
+	}
+}
+
+func newOpponentRecord(h *HeadToHead) OpponentRecord {
+	return OpponentRecord{
+		Opponent:   h.Opponent,
+		Games:      h.Results.Games,
+		Wins:       h.Results.Wins,
+		Draws:      h.Results.Draws,
+		Losses:     h.Results.Losses,
+		Score:      h.Results.Score(),
+		WhiteGames: h.White.Games,
+		WhiteScore: h.White.Score(),
+		BlackGames: h.Black.Games,
+		BlackScore: h.Black.Score(),
+		RatingDiff: h.RatingDiff(),
+		LastPlayed: h.Games[0].EndTime.UTC(),
+	}
+}
+
+// Opponents lists the user's most frequent opponents among the games matching
+// the filters, up to the limit, with the most recent games against each.
+func Opponents(cfg config) {
+	opponents := headToHeads(matchingGames(cfg), cfg.user)
+	if len(opponents) > cfg.limit {
+		opponents = opponents[:cfg.limit]
+	}
+
+	if isDataOutput(cfg.output) {
+		var records []OpponentRecord
+		for _, h := range opponents {
+			records = append(records, newOpponentRecord(h))
+		}
+		if err := writeRecords(os.Stdout, cfg.output, opponentCSVHeader, records); err != nil {
+			log.WithError(err).Fatal("Could not write opponents")
+		}
+		return
+	}
+
+	for i, h := range opponents {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Print(formatHeadToHead(h, cfg.user, cfg.last))
+	}
+}
+
+// Versus shows the full history of games against the opponent given as the
+// first argument, and how the user scored in each opening against them.
+func Versus(cfg config) {
+	if len(cfg.args) == 0 {
+		log.Fatal("Opponent username required")
+	}
+	cfg.filter.Opponent = cfg.args[0]
+
+	games := matchingGames(cfg)
+	if len(games) == 0 {
+		log.WithField("opponent", cfg.args[0]).Fatal("No games against opponent")
+	}
+
+	if isDataOutput(cfg.output) {
+		var records []GameRecord
+		for _, g := range games {
+			records = append(records, newGameRecord(g, cfg.user))
+		}
+		if err := writeRecords(os.Stdout, cfg.output, gameCSVHeader, records); err != nil {
+			log.WithError(err).Fatal("Could not write games")
+		}
+		return
+	}
+
+	h := headToHeads(games, cfg.user)[0]
+	fmt.Print(formatHeadToHead(h, cfg.user, len(h.Games)))
+	fmt.Println()
+	fmt.Print(formatOpeningStats(openingStats(games, cfg.user, cfg.group, 1, LoadAnalyses(cfg.user))))
+}
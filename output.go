@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/notnil/chess"
+)
+
+// Output formats shared by all commands. Text formats are specific to each
+// command.
+const (
+	outputJSON  = "json"
+	outputJSONL = "jsonl"
+	outputCSV   = "csv"
+)
+
+func isDataOutput(format string) bool {
+	switch format {
+	case outputJSON, outputJSONL, outputCSV:
+		return true
+	}
+	return false
+}
+
+// GameRecord is a game as written by search in json, jsonl and csv formats.
+// Fields are only ever added, never renamed or removed.
+type GameRecord struct {
+	ID        string       `json:"id"`
+	URL       string       `json:"url"`
+	EndTime   time.Time    `json:"end_time"`
+	Rated     bool         `json:"rated"`
+	TimeClass string       `json:"time_class"`
+	Rules     string       `json:"rules"`
+	White     PlayerRecord `json:"white"`
+	Black     PlayerRecord `json:"black"`
+	Result    string       `json:"result"`
+	// the user's color and result, empty if the user didn't play
+	Color      string `json:"color"`
+	UserResult string `json:"user_result"`
+	ECO        string `json:"eco"`
+	Opening    string `json:"opening"`
+	Plies      int    `json:"plies"`
+	// name of the opening classified by ECO
+	OpeningName string `json:"opening_name"`
+	// Chess.com result code saying how the game ended
+	Termination string `json:"termination"`
+	TimeControl string `json:"time_control"`
+	// Chess.com's own accuracies, if the game was reviewed there
+	Accuracies *Accuracies `json:"accuracies"`
+}
+
+type PlayerRecord struct {
+	Username string `json:"username"`
+	Rating   int    `json:"rating"`
+	Result   string `json:"result"`
+}
+
+var gameCSVHeader = []string{
+	"id", "url", "end_time", "rated", "time_class", "rules",
+	"white_username", "white_rating", "white_result",
+	"black_username", "black_rating", "black_result",
+	"result", "color", "user_result", "eco", "opening", "plies",
+	"opening_name", "termination", "time_control",
+	"white_accuracy", "black_accuracy",
+}
+
+func (r GameRecord) csvRow() []string {
+	return []string{
+		r.ID, r.URL, r.EndTime.Format(time.RFC3339),
+		strconv.FormatBool(r.Rated), r.TimeClass, r.Rules,
+		r.White.Username, strconv.Itoa(r.White.Rating), r.White.Result,
+		r.Black.Username, strconv.Itoa(r.Black.Rating), r.Black.Result,
+		r.Result, r.Color, r.UserResult, r.ECO, r.Opening, strconv.Itoa(r.Plies),
+		r.OpeningName, r.Termination, r.TimeControl,
+		accuracy(r.Accuracies, chess.White), accuracy(r.Accuracies, chess.Black),
+	}
+}
+
+// accuracy formats one color's Chess.com accuracy, or nothing if there is
+// none.
+func accuracy(a *Accuracies, c chess.Color) string {
+	if a == nil {
+		return ""
+	}
+	if c == chess.Black {
+		return strconv.FormatFloat(a.Black, 'f', 2, 64)
+	}
+	return strconv.FormatFloat(a.White, 'f', 2, 64)
+}
+
+// openingMoves is the number of moves included in GameRecord.Opening
+const openingMoves = 6
+
+func newGameRecord(g Game, user string) GameRecord {
+	r := GameRecord{
+		ID:        g.ID(),
+		EndTime:   g.EndTime.UTC(),
+		Rated:     g.Rated,
+		TimeClass: g.TimeClass,
+		Rules:     g.Rules,
+		White:     PlayerRecord{g.White.Username, g.White.Rating, g.White.Result},
+		Black:     PlayerRecord{g.Black.Username, g.Black.Rating, g.Black.Result},
+
+		Termination: g.Termination().String(),
+		TimeControl: g.TimeControl.Code(),
+		Accuracies:  g.Accuracies,
+	}
+	if g.URL != nil {
+		r.URL = g.URL.String()
+	}
+
+	switch user {
+	case g.White.Username:
+		r.Color = "white"
+		r.UserResult = g.White.NormalizedResult()
+	case g.Black.Username:
+		r.Color = "black"
+		r.UserResult = g.Black.NormalizedResult()
+	}
+
+	parsedGame, err := g.Game()
+	if err != nil {
+		log.WithError(err).WithField("url", g.URL).Warn("Could not parse game")
+		return r
+	}
+
+	r.Result = string(parsedGame.Outcome())
+	o := g.Opening()
+	r.ECO = o.ECO
+	r.OpeningName = o.Name
+	r.Plies = len(parsedGame.Moves())
+
+	nalg := chess.AlgebraicNotation{}
+	positions := parsedGame.Positions()
+	var opening []string
+	for i, m := range parsedGame.Moves() {
+		if i >= openingMoves*2 {
+			break
+		}
+		opening = append(opening, nalg.Encode(positions[i], m))
+	}
+	r.Opening = strings.Join(opening, " ")
+
+	return r
+}
+
+// AnalysisRecord is an analysed game as written in json format. In jsonl and
+// csv formats, only the plies are written, one per line.
+type AnalysisRecord struct {
+	Game    GameRecord      `json:"game"`
+	Engine  string          `json:"engine"`
+	Depth   int             `json:"depth"`
+	Summary []SummaryRecord `json:"summary"`
+	Plies   []PlyRecord     `json:"plies"`
+}
+
+type SummaryRecord struct {
+	Color        string  `json:"color"`
+	Username     string  `json:"username"`
+	Moves        int     `json:"moves"`
+	ACPL         float64 `json:"acpl"`
+	Accuracy     float64 `json:"accuracy"`
+	BestMoves    int     `json:"best_moves"`
+	Inaccuracies int     `json:"inaccuracies"`
+	Mistakes     int     `json:"mistakes"`
+	Blunders     int     `json:"blunders"`
+	// in the order opening, middlegame, endgame
+	Phases []PhaseSummaryRecord `json:"phases"`
+}
+
+type PhaseSummaryRecord struct {
+	Phase    string  `json:"phase"`
+	Moves    int     `json:"moves"`
+	ACPL     float64 `json:"acpl"`
+	Mistakes int     `json:"mistakes"`
+	Blunders int     `json:"blunders"`
+}
+
+// PlyRecord is a single analysed move. Evaluations are in pawns from white's
+// perspective, with forced mates given as +/-100 and the number of moves to
+// mate in the mate fields.
+type PlyRecord struct {
+	GameID     string  `json:"game_id"`
+	Ply        int     `json:"ply"`
+	MoveNumber string  `json:"move_number"`
+	Color      string  `json:"color"`
+	FEN        string  `json:"fen"`
+	Move       string  `json:"move"`
+	UCI        string  `json:"uci"`
+	EvalBefore float64 `json:"eval_before"`
+	MateBefore int     `json:"mate_before"`
+	EvalAfter  float64 `json:"eval_after"`
+	MateAfter  int     `json:"mate_after"`
+	BestMove   string  `json:"best_move"`
+	// engine's line from the position in algebraic notation, starting with
+	// the best move
+	BestLine       string  `json:"best_line"`
+	PointsLost     float64 `json:"points_lost"`
+	Classification string  `json:"classification"`
+	Phase          string  `json:"phase"`
+	// kind of critical moment, if the move was one
+	Critical string `json:"critical"`
+	// tactical motifs in the engine's line that the move missed, and in the
+	// reply it allowed, for inaccuracies and worse. Null for other moves.
+	MissedMotifs   []string `json:"missed_motifs"`
+	ConcededMotifs []string `json:"conceded_motifs"`
+	// seconds left on the clock after the move and spent on it, null if
+	// the game wasn't timed
+	Clock     *float64 `json:"clock"`
+	TimeSpent *float64 `json:"time_spent"`
+}
+
+var plyCSVHeader = []string{
+	"ply", "move_number", "color", "fen", "move", "uci",
+	"eval_before", "mate_before", "eval_after", "mate_after",
+	"best_move", "points_lost", "classification", "clock", "time_spent",
+	"phase", "best_line", "critical", "missed_motifs", "conceded_motifs",
+	"game_id",
+}
+
+func (r PlyRecord) csvRow() []string {
+	return []string{
+		strconv.Itoa(r.Ply), r.MoveNumber, r.Color, r.FEN, r.Move, r.UCI,
+		strconv.FormatFloat(r.EvalBefore, 'f', 2, 64), strconv.Itoa(r.MateBefore),
+		strconv.FormatFloat(r.EvalAfter, 'f', 2, 64), strconv.Itoa(r.MateAfter),
+		r.BestMove, strconv.FormatFloat(r.PointsLost, 'f', 4, 64), r.Classification,
+		seconds(r.Clock), seconds(r.TimeSpent), r.Phase, r.BestLine, r.Critical,
+		strings.Join(r.MissedMotifs, ";"), strings.Join(r.ConcededMotifs, ";"),
+		r.GameID,
+	}
+}
+
+// seconds formats an optional number of seconds, or nothing if there is none.
+func seconds(s *float64) string {
+	if s == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*s, 'f', 1, 64)
+}
+
+func newAnalysisRecord(g Game, user string, engine string, depth int, plies []Ply, summaries [2]Summary) AnalysisRecord {
+	r := AnalysisRecord{
+		Game:   newGameRecord(g, user),
+		Engine: engine,
+		Depth:  depth,
+	}
+
+	for _, s := range summaries {
+		var phaseRecords []PhaseSummaryRecord
+		for _, p := range phases {
+			ps := s.Phases[p]
+			phaseRecords = append(phaseRecords, PhaseSummaryRecord{
+				Phase:    p.String(),
+				Moves:    ps.Moves,
+				ACPL:     ps.ACPL,
+				Mistakes: ps.Mistakes,
+				Blunders: ps.Blunders,
+			})
+		}
+
+		r.Summary = append(r.Summary, SummaryRecord{
+			Color:        strings.ToLower(colorName(s.Color)),
+			Username:     s.Player,
+			Moves:        s.Moves,
+			ACPL:         s.ACPL,
+			Accuracy:     s.Accuracy,
+			BestMoves:    s.BestMoves,
+			Inaccuracies: s.Inaccuracies,
+			Mistakes:     s.Mistakes,
+			Blunders:     s.Blunders,
+			Phases:       phaseRecords,
+		})
+	}
+
+	critical := make(map[int]string)
+	for _, m := range criticalMoments(plies) {
+		critical[m.Index] = m.Kind.String()
+	}
+
+	for i, p := range plies {
+		var clock, spent *float64
+		if p.Timed {
+			c, s := p.Clock.Seconds(), p.Spent.Seconds()
+			clock, spent = &c, &s
+		}
+
+		r.Plies = append(r.Plies, PlyRecord{
+			GameID:         r.Game.ID,
+			Ply:            i + 1,
+			MoveNumber:     moveNumber(p.Position),
+			Color:          strings.ToLower(colorName(p.Position.Turn())),
+			FEN:            p.Position.String(),
+			Move:           p.SAN,
+			UCI:            p.Move.String(),
+			EvalBefore:     p.Before.Score,
+			MateBefore:     p.Before.Mate,
+			EvalAfter:      p.After.Score,
+			MateAfter:      p.After.Mate,
+			BestMove:       p.BestMove,
+			BestLine:       strings.Join(p.BestLine, " "),
+			PointsLost:     p.Lost,
+			Classification: p.Class.String(),
+			Phase:          p.Phase.String(),
+			Critical:       critical[i],
+			Clock:          clock,
+			TimeSpent:      spent,
+		})
+		if p.Class >= Inaccuracy {
+			last := &r.Plies[len(r.Plies)-1]
+			last.MissedMotifs = motifStrings(p.Missed)
+			last.ConcededMotifs = motifStrings(p.Conceded)
+		}
+	}
+
+	return r
+}
+
+// writeAnalyses writes analysed games in one of the data output formats. In
+// json format, a batch of games is written as an array, otherwise the single
+// game is written as an object.
+func writeAnalyses(w io.Writer, format string, records []AnalysisRecord, batch bool) error {
+	if format == outputJSON {
+		if !batch && len(records) == 1 {
+			return writeJSON(w, records[0])
+		}
+		return writeJSON(w, records)
+	}
+
+	var plies []PlyRecord
+	for _, a := range records {
+		plies = append(plies, a.Plies...)
+	}
+	return writeRecords(w, format, plyCSVHeader, plies)
+}
+
+// record is a single row of data output.
+type record interface {
+	csvRow() []string
+}
+
+// writeRecords writes rows, a slice of records of any type, in one of the
+// data output formats: a json array, one json object per line, or csv with
+// the header first.
+func writeRecords(w io.Writer, format string, header []string, rows interface{}) error {
+	v := reflect.ValueOf(rows)
+	switch format {
+	case outputJSON:
+		// no rows is an empty array rather than null
+		if v.IsNil() {
+			rows = reflect.MakeSlice(v.Type(), 0, 0).Interface()
+		}
+		return writeJSON(w, rows)
+	case outputJSONL:
+		e := json.NewEncoder(w)
+		for i := 0; i < v.Len(); i++ {
+			if err := e.Encode(v.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case outputCSV:
+		c := csv.NewWriter(w)
+		c.Write(header)
+		for i := 0; i < v.Len(); i++ {
+			c.Write(v.Index(i).Interface().(record).csvRow())
+		}
+		c.Flush()
+		return c.Error()
+	}
+	return fmt.Errorf("Unknown output format %s", format)
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(v)
+}
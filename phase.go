@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/notnil/chess"
+)
+
+// Phase is the stage of the game a position is in.
+type Phase int
+
+const (
+	OpeningPhase Phase = iota
+	MiddlegamePhase
+	EndgamePhase
+)
+
+// phases lists every phase in the order they're played.
+var phases = []Phase{OpeningPhase, MiddlegamePhase, EndgamePhase}
+
+// Thresholds for telling the phases apart, similar to those used by Lichess.
+// Pieces are knights, bishops, rooks and queens of both colors.
+const (
+	// the opening is over by this move even if neither side has developed
+	openingMaxMove = 15
+	// the middlegame starts once pieces have been traded down to this many,
+	// or either side has fewer than backRankDeveloped pieces left on its
+	// back rank
+	middlegamePieces  = 10
+	backRankDeveloped = 4
+	// the endgame starts once pieces have been traded down to this many
+	endgamePieces = 6
+)
+
+func (p Phase) String() string {
+	switch p {
+	case OpeningPhase:
+		return "opening"
+	case MiddlegamePhase:
+		return "middlegame"
+	default:
+		return "endgame"
+	}
+}
+
+// ParsePhase reads a phase written by String.
+func ParsePhase(s string) (Phase, error) {
+	for _, p := range phases {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return OpeningPhase, fmt.Errorf("Unknown phase %s", s)
+}
+
+// positionPhase returns the phase of a single position, ignoring the
+// positions that led to it.
+func positionPhase(pos *chess.Position) Phase {
+	var pieces, whiteBackRank, blackBackRank int
+	for sq, p := range pos.Board().SquareMap() {
+		switch p.Type() {
+		case chess.Knight, chess.Bishop, chess.Rook, chess.Queen:
+			pieces++
+		}
+		if p.Color() == chess.White && sq.Rank() == chess.Rank1 {
+			whiteBackRank++
+		}
+		if p.Color() == chess.Black && sq.Rank() == chess.Rank8 {
+			blackBackRank++
+		}
+	}
+
+	fields := strings.Fields(pos.String())
+	n, _ := strconv.Atoi(fields[len(fields)-1])
+
+	switch {
+	case pieces <= endgamePieces:
+		return EndgamePhase
+	case pieces <= middlegamePieces, n > openingMaxMove,
+		whiteBackRank < backRankDeveloped, blackBackRank < backRankDeveloped:
+		return MiddlegamePhase
+	}
+	return OpeningPhase
+}
+
+// gamePhases returns the phase of each position in a game. Phases only move
+// forward, so a game doesn't return to the opening when pieces go back to
+// the back rank.
+func gamePhases(positions []*chess.Position) []Phase {
+	result := make([]Phase, len(positions))
+	current := OpeningPhase
+	for i, pos := range positions {
+		if p := positionPhase(pos); p > current {
+			current = p
+		}
+		result[i] = current
+	}
+	return result
+}
+
+// recordPhases returns the phase of each ply in an analysed game. Analyses
+// cached before phases were recorded have them worked out from the FENs.
+func recordPhases(r AnalysisRecord) []Phase {
+	result := make([]Phase, len(r.Plies))
+	stored := true
+	for i, p := range r.Plies {
+		phase, err := ParsePhase(p.Phase)
+		if err != nil {
+			stored = false
+			break
+		}
+		result[i] = phase
+	}
+	if stored {
+		return result
+	}
+
+	var positions []*chess.Position
+	for _, p := range r.Plies {
+		pos := &chess.Position{}
+		if err := pos.UnmarshalText([]byte(p.FEN)); err != nil {
+			log.WithError(err).WithField("fen", p.FEN).Warn("Invalid FEN in analysis")
+			return result
+		}
+		positions = append(positions, pos)
+	}
+	return gamePhases(positions)
+}
+
+// PhaseStats is the quality of the user's moves in one phase across many
+// analysed games.
+type PhaseStats struct {
+	// games that reached the phase, and the lost ones where the user's
+	// biggest error was made in it
+	Games   int
+	Decided int
+
+	Moves        int
+	Inaccuracies int
+	Mistakes     int
+	Blunders     int
+	cpl          float64
+}
+
+// ACPL returns the average centipawn loss of the user's moves in the phase.
+func (s PhaseStats) ACPL() float64 {
+	if s.Moves == 0 {
+		return 0
+	}
+	return s.cpl / float64(s.Moves)
+}
+
+// BlundersPerGame returns how many blunders the user makes in the phase in
+// an average game that reaches it.
+func (s PhaseStats) BlundersPerGame() float64 {
+	if s.Games == 0 {
+		return 0
+	}
+	return float64(s.Blunders) / float64(s.Games)
+}
+
+// phaseStats totals the user's moves in each phase of the analysed games,
+// and returns how many of the games had been analysed.
+func phaseStats(games []Game, user string, analyses map[string]AnalysisRecord) ([]PhaseStats, int) {
+	stats := make([]PhaseStats, len(phases))
+	var analysed int
+	for _, g := range games {
+		a, ok := analyses[g.ID()]
+		if !ok {
+			continue
+		}
+		analysed++
+
+		color := "white"
+		if g.Black.Username == user {
+			color = "black"
+		}
+
+		var reached [EndgamePhase + 1]bool
+		worst, worstLost := -1, 0.0
+		for i, phase := range recordPhases(a) {
+			p := a.Plies[i]
+			if p.Color != color {
+				continue
+			}
+
+			s := &stats[phase]
+			if !reached[phase] {
+				reached[phase] = true
+				s.Games++
+			}
+			if p.Classification == Unclassified.String() {
+				continue
+			}
+			s.Moves++
+			s.cpl += centipawnLoss(p.EvalBefore, p.EvalAfter, p.Color == "black")
+			switch p.Classification {
+			case Inaccuracy.String():
+				s.Inaccuracies++
+			case Mistake.String():
+				s.Mistakes++
+			case Blunder.String():
+				s.Blunders++
+			}
+
+			if worst < 0 || p.PointsLost > worstLost {
+				worst, worstLost = int(phase), p.PointsLost
+			}
+		}
+
+		player := g.White
+		if color == "black" {
+			player = g.Black
+		}
+		if worst >= 0 && player.NormalizedResult() == "lose" {
+			stats[worst].Decided++
+		}
+	}
+	return stats, analysed
+}
+
+func formatPhaseStats(stats []PhaseStats, analysed int) string {
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "%d analysed games\n\n", analysed)
+	fmt.Fprintf(buf, "%-12s %5s %6s %5s %5s %5s %5s %9s %7s\n",
+		"phase", "games", "moves", "acpl", "?!", "?", "??", "??/game", "decided")
+	for i, s := range stats {
+		fmt.Fprintf(buf, "%-12s %5d %6d %5.0f %5d %5d %5d %9.2f %7d\n",
+			phases[i], s.Games, s.Moves, s.ACPL(), s.Inaccuracies, s.Mistakes,
+			s.Blunders, s.BlundersPerGame(), s.Decided)
+	}
+	return buf.String()
+}
+
+// PhaseStatsRecord is the quality of the user's moves in one phase, as
+// written by stats phases in json, jsonl and csv formats.
+type PhaseStatsRecord struct {
+	Phase           string  `json:"phase"`
+	Games           int     `json:"games"`
+	Moves           int     `json:"moves"`
+	ACPL            float64 `json:"acpl"`
+	Inaccuracies    int     `json:"inaccuracies"`
+	Mistakes        int     `json:"mistakes"`
+	Blunders        int     `json:"blunders"`
+	BlundersPerGame float64 `json:"blunders_per_game"`
+	Decided         int     `json:"decided"`
+}
+
+var phaseStatsCSVHeader = []string{
+	"phase", "games", "moves", "acpl", "inaccuracies", "mistakes", "blunders",
+	"blunders_per_game", "decided",
+}
+
+func (r PhaseStatsRecord) csvRow() []string {
+	return []string{
+		r.Phase, strconv.Itoa(r.Games), strconv.Itoa(r.Moves),
+		strconv.FormatFloat(r.ACPL, 'f', 1, 64),
+		strconv.Itoa(r.Inaccuracies), strconv.Itoa(r.Mistakes), strconv.Itoa(r.Blunders),
+		strconv.FormatFloat(r.BlundersPerGame, 'f', 2, 64), strconv.Itoa(r.Decided),
+	}
+}
+
+// StatsPhases reports how well the user plays the opening, middlegame and
+// endgame across analysed games matching the filters.
+func StatsPhases(cfg config) {
+	stats, analysed := phaseStats(matchingGames(cfg), cfg.user, LoadAnalyses(cfg.user))
+	if analysed == 0 {
+		log.WithField("user", cfg.user).Fatal("No analysed games, analyse some with -a all")
+	}
+
+	if isDataOutput(cfg.output) {
+		var records []PhaseStatsRecord
+		for i, s := range stats {
+			records = append(records, PhaseStatsRecord{
+				Phase:           phases[i].String(),
+				Games:           s.Games,
+				Moves:           s.Moves,
+				ACPL:            s.ACPL(),
+				Inaccuracies:    s.Inaccuracies,
+				Mistakes:        s.Mistakes,
+				Blunders:        s.Blunders,
+				BlundersPerGame: s.BlundersPerGame(),
+				Decided:         s.Decided,
+			})
+		}
+		if err := writeRecords(os.Stdout, cfg.output, phaseStatsCSVHeader, records); err != nil {
+			log.WithError(err).Fatal("Could not write statistics")
+		}
+		return
+	}
+
+	fmt.Print(formatPhaseStats(stats, analysed))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/notnil/chess"
+)
+
+// random values for Zobrist hashing, generated from a fixed seed so hashes
+// are stable between runs and can be persisted
+var zobrist struct {
+	pieces    [13][64]uint64
+	blackTurn uint64
+	castling  [4]uint64
+	enPassant [8]uint64
+}
+
+func init() {
+	r := rand.New(rand.NewSource(0x5eed))
+	for p := range zobrist.pieces {
+		for sq := range zobrist.pieces[p] {
+			zobrist.pieces[p][sq] = r.Uint64()
+		}
+	}
+	zobrist.blackTurn = r.Uint64()
+	for i := range zobrist.castling {
+		zobrist.castling[i] = r.Uint64()
+	}
+	for i := range zobrist.enPassant {
+		zobrist.enPassant[i] = r.Uint64()
+	}
+}
+
+// PositionHash returns the Zobrist hash of a position. Move clocks are
+// ignored, and the en passant square is only included when an en passant
+// capture is actually possible, so the same position reached by different
+// move orders hashes the same.
+func PositionHash(pos *chess.Position) uint64 {
+	var h uint64
+	for sq, p := range pos.Board().SquareMap() {
+		h ^= zobrist.pieces[p][sq]
+	}
+
+	if pos.Turn() == chess.Black {
+		h ^= zobrist.blackTurn
+	}
+
+	cr := pos.CastleRights()
+	for i, c := range []struct {
+		color chess.Color
+		side  chess.Side
+	}{
+		{chess.White, chess.KingSide},
+		{chess.White, chess.QueenSide},
+		{chess.Black, chess.KingSide},
+		{chess.Black, chess.QueenSide},
+	} {
+		if cr.CanCastle(c.color, c.side) {
+			h ^= zobrist.castling[i]
+		}
+	}
+
+	if fields := strings.Fields(pos.String()); len(fields) > 3 && fields[3] != "-" {
+		for _, m := range pos.ValidMoves() {
+			if m.HasTag(chess.EnPassant) {
+				h ^= zobrist.enPassant[m.S2().File()]
+				break
+			}
+		}
+	}
+
+	return h
+}
+
+// PositionIndex records which games passed through each position.
+type PositionIndex struct {
+	// IDs of games already indexed
+	Games map[string]bool `json:"games"`
+	// position hash to IDs of games that reached it
+	Positions map[uint64][]string `json:"positions"`
+}
+
+func newPositionIndex() *PositionIndex {
+	return &PositionIndex{
+		Games:     make(map[string]bool),
+		Positions: make(map[uint64][]string),
+	}
+}
+
+// Update adds any games not yet in the index, returning how many were added.
+func (idx *PositionIndex) Update(games []Game) int {
+	var count int
+	for i := range games {
+		g := &games[i]
+		id := g.ID()
+		if idx.Games[id] {
+			continue
+		}
+
+		game, err := g.Game()
+		if err != nil {
+			log.WithError(err).WithField("url", g.URL).Warn("Could not parse game")
+			continue
+		}
+
+		seen := make(map[uint64]bool)
+		for _, pos := range game.Positions() {
+			h := PositionHash(pos)
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			idx.Positions[h] = append(idx.Positions[h], id)
+		}
+
+		idx.Games[id] = true
+		count++
+	}
+	return count
+}
+
+// Find returns the IDs of games that reached the position with hash h.
+func (idx *PositionIndex) Find(h uint64) map[string]bool {
+	ids := make(map[string]bool)
+	for _, id := range idx.Positions[h] {
+		ids[id] = true
+	}
+	return ids
+}
+
+// gamesReaching returns the IDs of games that reached the position with hash
+// h, using the user's cached position index.
+func gamesReaching(user string, games []Game, h uint64) map[string]bool {
+	idx := LoadPositionIndex(user)
+	if n := idx.Update(games); n > 0 {
+		log.WithFields(log.Fields{
+			"user":  user,
+			"count": n,
+		}).Info("Indexed positions of new games")
+		SavePositionIndex(user, idx)
+	}
+	return idx.Find(h)
+}
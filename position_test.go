@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+// gameAfter plays moves in algebraic notation from the starting position.
+func gameAfter(t *testing.T, moves string) *chess.Game {
+	t.Helper()
+	g := chess.NewGame()
+	for _, s := range strings.Fields(moves) {
+		if err := g.MoveStr(s); err != nil {
+			t.Fatalf("%s in %q: %v", s, moves, err)
+		}
+	}
+	return g
+}
+
+// positionFromFEN reads a position from FEN.
+func positionFromFEN(t *testing.T, fen string) *chess.Position {
+	t.Helper()
+	pos := &chess.Position{}
+	if err := pos.UnmarshalText([]byte(fen)); err != nil {
+		t.Fatalf("%s: %v", fen, err)
+	}
+	return pos
+}
+
+func TestPositionHashMoveOrder(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"Nf3 d5 d4", "d4 d5 Nf3", true},
+		{"e4 e5 Nf3 Nc6 Bb5", "Nf3 Nc6 e4 e5 Bb5", true},
+		// the same position after knights return, with different clocks
+		{"", "Nf3 Nf6 Ng1 Ng8", true},
+		{"e4", "e3 e5 e4", false},
+		// same pieces, but castling rights are lost by the king moving
+		{"e4 e5 Ke2 Ke7 Ke1 Ke8", "e4 e5", false},
+	}
+
+	for _, tt := range tests {
+		a := PositionHash(gameAfter(t, tt.a).Position())
+		b := PositionHash(gameAfter(t, tt.b).Position())
+		if (a == b) != tt.same {
+			t.Errorf("%q and %q: same hash %v, want %v", tt.a, tt.b, a == b, tt.same)
+		}
+	}
+}
+
+func TestPositionHashFEN(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{
+			"side to move",
+			"4k3/8/8/8/8/8/8/4K3 w - - 0 1",
+			"4k3/8/8/8/8/8/8/4K3 b - - 0 1",
+			false,
+		},
+		{
+			"castling rights",
+			"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1",
+			"r3k2r/8/8/8/8/8/8/R3K2R w Qkq - 0 1",
+			false,
+		},
+		{
+			"move clocks",
+			"4k3/8/8/8/8/8/8/4K3 w - - 0 1",
+			"4k3/8/8/8/8/8/8/4K3 w - - 12 40",
+			true,
+		},
+		{
+			"en passant square with no capture",
+			"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1",
+			"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1",
+			true,
+		},
+		{
+			"en passant capture possible",
+			"rnbqkbnr/ppp1pppp/8/8/3pP3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 3",
+			"rnbqkbnr/ppp1pppp/8/8/3pP3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 3",
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		a := PositionHash(positionFromFEN(t, tt.a))
+		b := PositionHash(positionFromFEN(t, tt.b))
+		if (a == b) != tt.same {
+			t.Errorf("%s: same hash %v, want %v", tt.name, a == b, tt.same)
+		}
+	}
+}
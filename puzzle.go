@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/notnil/chess"
+)
+
+const (
+	// expected points the best move must be worth over the second best for
+	// it to be the only solution
+	puzzleMargin = 0.2
+	// longest solution, in the user's moves
+	maxPuzzleMoves = 3
+)
+
+// Puzzle is a position from one of the user's games where they made a
+// mistake, with the moves the engine found instead.
+type Puzzle struct {
+	// game ID and ply, e.g. "15000000060-6"
+	ID         string    `json:"id"`
+	GameID     string    `json:"game_id"`
+	URL        string    `json:"url"`
+	Date       time.Time `json:"date"`
+	White      string    `json:"white"`
+	Black      string    `json:"black"`
+	Ply        int       `json:"ply"`
+	MoveNumber string    `json:"move_number"`
+	Color      string    `json:"color"`
+	FEN        string    `json:"fen"`
+	Phase      string    `json:"phase"`
+	// the mistake made in the game, in algebraic notation
+	Played         string `json:"played"`
+	Classification string `json:"classification"`
+	// the user's moves and the opponent's replies, ending with the user's
+	// move, in UCI and algebraic notation. Empty if the position has no
+	// single clear solution.
+	Solution    []string `json:"solution"`
+	SolutionSAN []string `json:"solution_san"`
+}
+
+// Position returns the position the puzzle starts from.
+func (p Puzzle) Position() (*chess.Position, error) {
+	pos := &chess.Position{}
+	if err := pos.UnmarshalText([]byte(p.FEN)); err != nil {
+		return nil, fmt.Errorf("Invalid FEN %s: %w", p.FEN, err)
+	}
+	return pos, nil
+}
+
+// puzzleCandidates returns unverified puzzles for the user's mistakes and
+// blunders in an analysed game.
+func puzzleCandidates(g Game, a AnalysisRecord, user string) []Puzzle {
+	color := "white"
+	if g.Black.Username == user {
+		color = "black"
+	}
+
+	var puzzles []Puzzle
+	phaseOf := recordPhases(a)
+	for i, r := range a.Plies {
+		if r.Color != color {
+			continue
+		}
+		if r.Classification != Mistake.String() && r.Classification != Blunder.String() {
+			continue
+		}
+
+		p := Puzzle{
+			ID:             fmt.Sprintf("%s-%d", g.ID(), r.Ply),
+			GameID:         g.ID(),
+			Date:           g.EndTime.UTC(),
+			White:          g.White.Username,
+			Black:          g.Black.Username,
+			Ply:            r.Ply,
+			MoveNumber:     r.MoveNumber,
+			Color:          r.Color,
+			FEN:            r.FEN,
+			Phase:          phaseOf[i].String(),
+			Played:         r.Move,
+			Classification: r.Classification,
+		}
+		if g.URL != nil {
+			p.URL = g.URL.String()
+		}
+		puzzles = append(puzzles, p)
+	}
+	return puzzles
+}
+
+// onlyMove returns whether the engine's best move is clearly better than its
+// second best, or the only legal move.
+func onlyMove(r Result) bool {
+	if len(r.Alternatives) == 0 {
+		return true
+	}
+	return r.ExpectedScore()-r.Alternatives[0].ExpectedScore() >= puzzleMargin
+}
+
+// solvePuzzle finds the solution to a puzzle with a, which must search at
+// least two lines. The solution follows the engine's line for as long as the
+// user has a single clearly best move, up to maxPuzzleMoves. Positions where
+// the first move isn't clearly best have no solution.
+func solvePuzzle(a Analyzer, p *Puzzle) error {
+	pos, err := p.Position()
+	if err != nil {
+		return err
+	}
+
+	nalg := chess.AlgebraicNotation{}
+	p.Solution, p.SolutionSAN = nil, nil
+	for len(p.Solution) < maxPuzzleMoves*2 {
+		// the user's first move has to be a choice
+		if len(p.Solution) == 0 && len(pos.ValidMoves()) < 2 {
+			break
+		}
+
+		r := a.Analyze(pos.String())
+		if err := a.Err(); err != nil {
+			return err
+		}
+		if r.Err != nil || !r.hasEval() || !onlyMove(r) {
+			break
+		}
+
+		// the user's move, then the opponent's reply if the engine has one
+		line := r.PV
+		if len(line) == 0 {
+			line = []string{r.BestMove}
+		}
+		if len(line) > 2 {
+			line = line[:2]
+		}
+		for _, s := range line {
+			m, err := decodeMove(pos, s)
+			if err != nil {
+				return fmt.Errorf("Invalid move %s in engine line: %w", s, err)
+			}
+			p.Solution = append(p.Solution, s)
+			p.SolutionSAN = append(p.SolutionSAN, nalg.Encode(pos, m))
+			pos = pos.Update(m)
+			if pos.Status() != chess.NoMethod {
+				break
+			}
+		}
+		if len(line) < 2 || pos.Status() != chess.NoMethod {
+			break
+		}
+	}
+
+	// end on the user's move
+	if len(p.Solution)%2 == 0 && len(p.Solution) > 0 {
+		p.Solution = p.Solution[:len(p.Solution)-1]
+		p.SolutionSAN = p.SolutionSAN[:len(p.SolutionSAN)-1]
+	}
+	return nil
+}
+
+// puzzlePGN formats a puzzle as a PGN game starting from its position, with
+// the move played in the game as a comment.
+func puzzlePGN(p Puzzle) (string, error) {
+	pos, err := p.Position()
+	if err != nil {
+		return "", err
+	}
+
+	buf := &strings.Builder{}
+	tags := []chess.TagPair{
+		{Key: "Event", Value: "Puzzle " + p.ID},
+		{Key: "Site", Value: p.URL},
+		{Key: "Date", Value: p.Date.Format("2006.01.02")},
+		{Key: "Round", Value: "-"},
+		{Key: "White", Value: p.White},
+		{Key: "Black", Value: p.Black},
+		{Key: "Result", Value: "*"},
+		{Key: "SetUp", Value: "1"},
+		{Key: "FEN", Value: p.FEN},
+	}
+	for _, t := range tags {
+		fmt.Fprintf(buf, "[%s \"%s\"]\n", t.Key, escapeTagValue(t.Value))
+	}
+	buf.WriteString("\n")
+
+	w := &movetextWriter{buf: buf}
+	w.comment(fmt.Sprintf("%s %s was played", p.MoveNumber, p.Played))
+	for i, san := range p.SolutionSAN {
+		w.move(pos, san)
+		m, err := decodeMove(pos, p.Solution[i])
+		if err != nil {
+			return "", err
+		}
+		pos = pos.Update(m)
+	}
+	w.token("*")
+	buf.WriteString("\n")
+
+	return buf.String(), nil
+}
+
+var puzzleCSVHeader = []string{
+	"id", "game_id", "url", "date", "white", "black", "ply", "move_number",
+	"color", "fen", "phase", "played", "classification", "solution",
+	"solution_san",
+}
+
+func (p Puzzle) csvRow() []string {
+	return []string{
+		p.ID, p.GameID, p.URL, p.Date.Format(time.RFC3339), p.White, p.Black,
+		strconv.Itoa(p.Ply), p.MoveNumber, p.Color, p.FEN, p.Phase, p.Played,
+		p.Classification, strings.Join(p.Solution, " "),
+		strings.Join(p.SolutionSAN, " "),
+	}
+}
+
+// userPuzzles returns puzzles from the user's mistakes in analysed games
+// matching the filters, newest first, up to the limit. New positions are
+// checked with the engine and cached, along with those that have no clear
+// solution so they aren't checked again.
+func userPuzzles(cfg config) []Puzzle {
+	analyses := LoadAnalyses(cfg.user)
+	cached := LoadPuzzles(cfg.user)
+
+	var e *Engine
+	defer func() {
+		if e != nil {
+			e.Close()
+		}
+	}()
+
+	var puzzles []Puzzle
+	for _, g := range matchingGames(cfg) {
+		if len(puzzles) >= cfg.limit {
+			break
+		}
+		a, ok := analyses[g.ID()]
+		if !ok {
+			continue
+		}
+
+		var solved bool
+		for _, p := range puzzleCandidates(g, a, cfg.user) {
+			if c, ok := cached[p.ID]; ok {
+				p = c
+			} else {
+				if e == nil {
+					var err error
+					if e, err = newPuzzleEngine(cfg); err != nil {
+						log.WithError(err).WithField("engine", cfg.engine).
+							Fatal("Could not initialise analysis engine")
+					}
+				}
+				if err := solvePuzzle(e, &p); err != nil {
+					log.WithError(err).WithField("id", p.ID).Warn("Could not solve puzzle")
+					continue
+				}
+				cached[p.ID] = p
+				solved = true
+			}
+
+			if len(p.Solution) > 0 {
+				puzzles = append(puzzles, p)
+			}
+		}
+		if solved {
+			SavePuzzles(cfg.user, cached)
+		}
+	}
+
+	if len(puzzles) > cfg.limit {
+		puzzles = puzzles[:cfg.limit]
+	}
+	return puzzles
+}
+
+// newPuzzleEngine starts the engine to check puzzles with, searching two
+// lines so it can tell whether the best move is the only good one.
+func newPuzzleEngine(cfg config) (*Engine, error) {
+	e, err := NewEngine(cfg.engine, cfg.depth, cfg.timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.SetMultiPV(2); err != nil {
+		e.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// Puzzles writes puzzles made from the user's mistakes as PGN, or in one of
+// the data output formats.
+func Puzzles(cfg config) {
+	puzzles := userPuzzles(cfg)
+	if len(puzzles) == 0 {
+		log.WithField("user", cfg.user).Fatal("No puzzles, analyse some games with -a all")
+	}
+
+	if isDataOutput(cfg.output) {
+		if err := writeRecords(os.Stdout, cfg.output, puzzleCSVHeader, puzzles); err != nil {
+			log.WithError(err).Fatal("Could not write puzzles")
+		}
+		return
+	}
+
+	for i, p := range puzzles {
+		pgn, err := puzzlePGN(p)
+		if err != nil {
+			log.WithError(err).WithField("id", p.ID).Warn("Could not write puzzle")
+			continue
+		}
+
+		switch cfg.output {
+		case "url":
+			fmt.Printf("https://chess.com/analysis?pgn=%s\n", url.QueryEscape(pgn))
+		default:
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Print(pgn)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/notnil/chess"
+)
+
+// Repertoire is a tree of prepared moves, keyed by position so lines that
+// transpose into each other are treated as one.
+type Repertoire struct {
+	// position hash to the moves prepared there, in UCI notation. Positions
+	// at the end of a line are present with no moves.
+	Moves map[uint64][]string
+}
+
+// repertoireToken matches a single token of PGN movetext.
+var repertoireToken = regexp.MustCompile(`\{[^}]*\}|;[^\n]*|\[[^\]]*\]|[()]|[^\s(){};\[]+`)
+
+var (
+	moveNumberPrefix = regexp.MustCompile(`^\d+\.*`)
+	fenTag           = regexp.MustCompile(`^\[FEN\s+"([^"]*)"\]$`)
+)
+
+// ParseRepertoire reads a PGN file of one or more games with variations,
+// which the PGN parser used for games ignores. Every game and variation is
+// added to the same repertoire.
+func ParseRepertoire(r io.Reader) (*Repertoire, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := &Repertoire{Moves: make(map[uint64][]string)}
+
+	// the start position of the current game, and the position before and
+	// after the last move, which variations branch from
+	type state struct {
+		pos, prev *chess.Position
+	}
+	start := chess.StartingPosition()
+	cur := state{pos: start}
+	var stack []state
+	// whether the current game has any moves, so a tag after them starts
+	// the next game even without a result
+	var movetext bool
+
+	for _, tok := range repertoireToken.FindAllString(string(data), -1) {
+		switch {
+		case strings.HasPrefix(tok, "{"), strings.HasPrefix(tok, ";"),
+			strings.HasPrefix(tok, "$"):
+			continue
+		case strings.HasPrefix(tok, "["):
+			if movetext {
+				if len(stack) > 0 {
+					return nil, fmt.Errorf("Unterminated variation")
+				}
+				start = chess.StartingPosition()
+				cur = state{pos: start}
+				movetext = false
+			}
+			if m := fenTag.FindStringSubmatch(tok); m != nil {
+				fen, err := chess.FEN(m[1])
+				if err != nil {
+					return nil, fmt.Errorf("Invalid FEN %s: %w", m[1], err)
+				}
+				start = chess.NewGame(fen).Position()
+				cur = state{pos: start}
+			}
+			continue
+		case tok == "(":
+			if cur.prev == nil {
+				return nil, fmt.Errorf("Variation before any move")
+			}
+			stack = append(stack, cur)
+			cur = state{pos: cur.prev}
+			continue
+		case tok == ")":
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("Unexpected end of variation")
+			}
+			cur = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			continue
+		case tok == "1-0", tok == "0-1", tok == "1/2-1/2", tok == "*":
+			// next game
+			if len(stack) > 0 {
+				return nil, fmt.Errorf("Unterminated variation")
+			}
+			start = chess.StartingPosition()
+			cur = state{pos: start}
+			movetext = false
+			continue
+		}
+
+		san := moveNumberPrefix.ReplaceAllString(tok, "")
+		if san == "" {
+			continue
+		}
+
+		m, err := decodeMove(cur.pos, san)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid move %s in repertoire: %w", tok, err)
+		}
+		rep.add(cur.pos, m)
+		cur = state{pos: cur.pos.Update(m), prev: cur.pos}
+		movetext = true
+	}
+
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("Unterminated variation")
+	}
+	return rep, nil
+}
+
+func (rep *Repertoire) add(pos *chess.Position, m *chess.Move) {
+	h := PositionHash(pos)
+	if !containsString(rep.Moves[h], m.String()) {
+		rep.Moves[h] = append(rep.Moves[h], m.String())
+	}
+
+	next := PositionHash(pos.Update(m))
+	if _, ok := rep.Moves[next]; !ok {
+		rep.Moves[next] = nil
+	}
+}
+
+// Deviation is where a game left the repertoire.
+type Deviation struct {
+	Game Game
+	// index of the move that left the repertoire, or -1 if the game
+	// followed it to the end of a line or the end of the game
+	Ply int
+	// whether the user made the move
+	ByUser bool
+	// position and move played, and the moves prepared instead in SAN
+	Position   *chess.Position
+	Played     string
+	Repertoire []string
+	// moves up to the deviation in SAN
+	Line []string
+}
+
+// deviation finds where a game left the repertoire.
+func (rep *Repertoire) deviation(g *Game, user string) (Deviation, error) {
+	d := Deviation{Game: *g, Ply: -1}
+
+	parsedGame, err := g.Game()
+	if err != nil {
+		return d, err
+	}
+
+	userColor := chess.White
+	if g.Black.Username == user {
+		userColor = chess.Black
+	}
+
+	nalg := chess.AlgebraicNotation{}
+	positions := parsedGame.Positions()
+	for i, m := range parsedGame.Moves() {
+		pos := positions[i]
+		prepared, ok := rep.Moves[PositionHash(pos)]
+		if ok && len(prepared) == 0 {
+			// end of the line
+			return d, nil
+		}
+		if ok && containsString(prepared, m.String()) {
+			d.Line = append(d.Line, nalg.Encode(pos, m))
+			continue
+		}
+
+		d.Ply = i
+		d.ByUser = pos.Turn() == userColor
+		d.Position = pos
+		d.Played = nalg.Encode(pos, m)
+		for _, uci := range prepared {
+			if pm, err := (chess.UCINotation{}).Decode(pos, uci); err == nil {
+				d.Repertoire = append(d.Repertoire, nalg.Encode(pos, pm))
+			}
+		}
+		return d, nil
+	}
+
+	return d, nil
+}
+
+// who returns who left the repertoire.
+func (d Deviation) who() string {
+	switch {
+	case d.Ply < 0:
+		return ""
+	case d.ByUser:
+		return "user"
+	}
+	return "opponent"
+}
+
+// lineString formats a line of SAN moves with move numbers, starting from
+// the first move of the game.
+func lineString(line []string) string {
+	buf := &strings.Builder{}
+	for i, san := range line {
+		if i > 0 {
+			buf.WriteString(" ")
+		}
+		if i%2 == 0 {
+			fmt.Fprintf(buf, "%d.", i/2+1)
+		}
+		buf.WriteString(san)
+	}
+	return buf.String()
+}
+
+func (d Deviation) String() string {
+	prefix := fmt.Sprintf("%s [%s]", d.Game.EndTime.Format("02/01"), d.Game.URL)
+	switch {
+	case d.Ply < 0:
+		return fmt.Sprintf("%s followed the repertoire: %s", prefix, lineString(d.Line))
+	case len(d.Repertoire) == 0 && d.Ply == 0:
+		return fmt.Sprintf("%s not in the repertoire", prefix)
+	}
+
+	who := "you"
+	if !d.ByUser {
+		who = "opponent"
+	}
+	return fmt.Sprintf("%s %s left at %s %s, repertoire %s",
+		prefix, who, moveNumber(d.Position), d.Played, strings.Join(d.Repertoire, ", "))
+}
+
+// forgottenBranch is a position where the user left the repertoire.
+type forgottenBranch struct {
+	Line       []string
+	MoveNumber string
+	Repertoire []string
+	Count      int
+	// times each wrong move was played
+	Played map[string]int
+}
+
+// forgottenBranches groups the user's deviations by position, most often
+// forgotten first.
+func forgottenBranches(deviations []Deviation) []*forgottenBranch {
+	branches := make(map[uint64]*forgottenBranch)
+	for _, d := range deviations {
+		if d.Ply < 0 || !d.ByUser || len(d.Repertoire) == 0 {
+			continue
+		}
+
+		h := PositionHash(d.Position)
+		b, ok := branches[h]
+		if !ok {
+			b = &forgottenBranch{
+				Line:       d.Line,
+				MoveNumber: moveNumber(d.Position),
+				Repertoire: d.Repertoire,
+				Played:     make(map[string]int),
+			}
+			branches[h] = b
+		}
+		b.Count++
+		b.Played[d.Played]++
+	}
+
+	var sorted []*forgottenBranch
+	for _, b := range branches {
+		sorted = append(sorted, b)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return strings.Join(sorted[i].Line, " ") < strings.Join(sorted[j].Line, " ")
+	})
+	return sorted
+}
+
+func formatForgottenBranches(branches []*forgottenBranch) string {
+	buf := &strings.Builder{}
+	fmt.Fprintln(buf, "Most forgotten branches")
+	if len(branches) == 0 {
+		fmt.Fprintln(buf, "none")
+	}
+	for _, b := range branches {
+		var played []string
+		for san, n := range b.Played {
+			played = append(played, fmt.Sprintf("%s (%d)", san, n))
+		}
+		sort.Strings(played)
+
+		line := lineString(b.Line)
+		if line == "" {
+			line = "start"
+		}
+		fmt.Fprintf(buf, "%3d  after %s: repertoire %s %s, played %s\n",
+			b.Count, line, b.MoveNumber, strings.Join(b.Repertoire, ", "),
+			strings.Join(played, ", "))
+	}
+	return buf.String()
+}
+
+// DeviationRecord is where a game left the repertoire, as written in json,
+// jsonl and csv formats.
+type DeviationRecord struct {
+	GameID string `json:"game_id"`
+	URL    string `json:"url"`
+	// ply that left the repertoire, or 0 if it was followed to the end
+	Ply        int      `json:"ply"`
+	MoveNumber string   `json:"move_number"`
+	By         string   `json:"by"`
+	FEN        string   `json:"fen"`
+	Played     string   `json:"played"`
+	Repertoire []string `json:"repertoire"`
+	Line       string   `json:"line"`
+}
+
+var deviationCSVHeader = []string{
+	"game_id", "url", "ply", "move_number", "by", "fen", "played",
+	"repertoire", "line",
+}
+
+func (r DeviationRecord) csvRow() []string {
+	return []string{
+		r.GameID, r.URL, strconv.Itoa(r.Ply), r.MoveNumber, r.By, r.FEN,
+		r.Played, strings.Join(r.Repertoire, " "), r.Line,
+	}
+}
+
+func newDeviationRecord(d Deviation) DeviationRecord {
+	r := DeviationRecord{
+		GameID:     d.Game.ID(),
+		By:         d.who(),
+		Played:     d.Played,
+		Repertoire: d.Repertoire,
+		Line:       strings.Join(d.Line, " "),
+	}
+	if d.Game.URL != nil {
+		r.URL = d.Game.URL.String()
+	}
+	if d.Ply >= 0 {
+		r.Ply = d.Ply + 1
+		r.MoveNumber = moveNumber(d.Position)
+		r.FEN = d.Position.String()
+	}
+	return r
+}
+
+// CheckRepertoire reports where the latest games matching the filters left
+// the repertoire given as the first argument, and which branches the user
+// forgets most often across all of them.
+func CheckRepertoire(cfg config) {
+	if len(cfg.args) == 0 {
+		log.Fatal("Repertoire file required")
+	}
+
+	f, err := os.Open(cfg.args[0])
+	if err != nil {
+		log.WithError(err).WithField("path", cfg.args[0]).
+			Fatal("Could not open repertoire")
+	}
+	rep, err := ParseRepertoire(f)
+	f.Close()
+	if err != nil {
+		log.WithError(err).WithField("path", cfg.args[0]).
+			Fatal("Could not read repertoire")
+	}
+
+	games := matchingGames(cfg)
+
+	var deviations []Deviation
+	for i := range games {
+		d, err := rep.deviation(&games[i], cfg.user)
+		if err != nil {
+			log.WithError(err).WithField("url", games[i].URL).Warn("Could not parse game")
+			continue
+		}
+		deviations = append(deviations, d)
+	}
+
+	if isDataOutput(cfg.output) {
+		var records []DeviationRecord
+		for _, d := range deviations {
+			records = append(records, newDeviationRecord(d))
+		}
+		if err := writeRecords(os.Stdout, cfg.output, deviationCSVHeader, records); err != nil {
+			log.WithError(err).Fatal("Could not write deviations")
+		}
+		return
+	}
+
+	// every game counts towards the forgotten branches, but only the latest
+	// are listed
+	for i := 0; i < len(deviations) && i < cfg.limit; i++ {
+		fmt.Println(deviations[i])
+	}
+	fmt.Println()
+	fmt.Print(formatForgottenBranches(forgottenBranches(deviations)))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseRepertoire(t *testing.T) {
+	tests := []struct {
+		name string
+		pgn  string
+		// moves prepared after the given moves from the starting position,
+		// in UCI notation
+		want map[string][]string
+	}{
+		{
+			name: "single line",
+			pgn:  "1. e4 e5 2. Nf3 *",
+			want: map[string][]string{
+				"":          {"e2e4"},
+				"e4":        {"e7e5"},
+				"e4 e5":     {"g1f3"},
+				"e4 e5 Nf3": nil,
+			},
+		},
+		{
+			name: "variations",
+			pgn:  "1. e4 e5 (1... c5 2. Nf3 (2. c3) d6) (1... e6) 2. Nf3 *",
+			want: map[string][]string{
+				"":          {"e2e4"},
+				"e4":        {"e7e5", "c7c5", "e7e6"},
+				"e4 c5":     {"g1f3", "c2c3"},
+				"e4 c5 Nf3": {"d7d6"},
+				"e4 c5 c3":  nil,
+				"e4 e6":     nil,
+				"e4 e5":     {"g1f3"},
+			},
+		},
+		{
+			name: "comments and annotations",
+			pgn: `[Event "Repertoire"]
+{ main line } 1. d4! $1 d5 ; the only move
+2. c4 {Queen's Gambit} *`,
+			want: map[string][]string{
+				"":      {"d2d4"},
+				"d4":    {"d7d5"},
+				"d4 d5": {"c2c4"},
+			},
+		},
+		{
+			name: "transposition",
+			pgn:  "1. d4 d5 2. Nf3 * 1. Nf3 d5 2. d4 Nf6 *",
+			want: map[string][]string{
+				"":          {"d2d4", "g1f3"},
+				"d4 d5 Nf3": {"g8f6"},
+				"Nf3 d5 d4": {"g8f6"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		rep, err := ParseRepertoire(strings.NewReader(tt.pgn))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		for moves, want := range tt.want {
+			got, ok := rep.Moves[PositionHash(gameAfter(t, moves).Position())]
+			if !ok {
+				t.Errorf("%s: position after %q not in repertoire", tt.name, moves)
+				continue
+			}
+			if !sameStrings(got, want) {
+				t.Errorf("%s: moves after %q = %v, want %v", tt.name, moves, got, want)
+			}
+		}
+	}
+}
+
+func TestParseRepertoireFENPosition(t *testing.T) {
+	rep, err := ParseRepertoire(strings.NewReader(
+		`[FEN "4k3/8/8/8/8/8/4P3/4K3 w - - 0 1"] 1. e4 Kd7 *`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pos := positionFromFEN(t, "4k3/8/8/8/8/8/4P3/4K3 w - - 0 1")
+	if got := rep.Moves[PositionHash(pos)]; !sameStrings(got, []string{"e2e4"}) {
+		t.Errorf("moves from FEN = %v, want [e2e4]", got)
+	}
+	if _, ok := rep.Moves[PositionHash(gameAfter(t, "").Position())]; ok {
+		t.Errorf("starting position in repertoire of a FEN game")
+	}
+}
+
+func TestParseRepertoireWithoutResults(t *testing.T) {
+	rep, err := ParseRepertoire(strings.NewReader(`[Event "Sicilian"]
+
+1. e4 c5
+
+[Event "Endgame"]
+[FEN "4k3/8/8/8/8/8/4P3/4K3 w - - 0 1"]
+
+1. e4 Kd7
+
+[Event "French"]
+
+1. e4 e6`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := rep.Moves[PositionHash(gameAfter(t, "e4").Position())]; !sameStrings(got, []string{"c7c5", "e7e6"}) {
+		t.Errorf("moves after 1. e4 = %v, want [c7c5 e7e6]", got)
+	}
+	pos := positionFromFEN(t, "4k3/8/8/8/8/8/4P3/4K3 w - - 0 1")
+	if got := rep.Moves[PositionHash(pos)]; !sameStrings(got, []string{"e2e4"}) {
+		t.Errorf("moves from FEN = %v, want [e2e4]", got)
+	}
+}
+
+func TestParseRepertoireErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		pgn  string
+	}{
+		{"unterminated variation", "1. e4 e5 (1... c5 2. Nf3 *"},
+		{"variation before any move", "(1. d4) 1. e4 *"},
+		{"unexpected end of variation", "1. e4 e5 ) 2. Nf3 *"},
+		{"illegal move", "1. e4 e5 2. Ke3 *"},
+		{"invalid move", "1. e4 xyz *"},
+		{"invalid FEN", `[FEN "not a position"] 1. e4 *`},
+		{"variation open at next game", "1. e4 e5 (1... c5 [Event \"Next\"] 1. d4 *"},
+	}
+
+	for _, tt := range tests {
+		if _, err := ParseRepertoire(strings.NewReader(tt.pgn)); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+// sameStrings returns whether a and b hold the same strings in any order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/notnil/chess"
+)
+
+// Stats reports statistics over the user's games matching the filters.
+func Stats(cfg config) {
+	var kind string
+	if len(cfg.args) > 0 {
+		kind = cfg.args[0]
+	}
+
+	switch kind {
+	case "":
+		StatsRatings(cfg)
+	case "openings":
+		StatsOpenings(cfg)
+	case "time":
+		StatsTime(cfg)
+	case "phases":
+		StatsPhases(cfg)
+	case "motifs":
+		StatsMotifs(cfg)
+	default:
+		log.WithField("stats", kind).Fatal("Unknown statistics")
+	}
+}
+
+// OpeningStats is how the user scored in an opening with one color.
+type OpeningStats struct {
+	Opening string `json:"opening"`
+	Color   string `json:"color"`
+	ResultStats
+	// percentage of points scored
+	Score float64 `json:"score"`
+	// average of the user's rating minus the opponent's
+	RatingDiff float64 `json:"rating_diff"`
+	// number of analysed games and the user's average accuracy in them
+	Analysed int     `json:"analysed"`
+	Accuracy float64 `json:"accuracy"`
+	// score in the newer half of the games minus the older half, zero with
+	// fewer than 4 games
+	Trend float64 `json:"trend"`
+
+	// games newest first
+	games []Game
+}
+
+var openingStatsCSVHeader = []string{
+	"opening", "color", "games", "wins", "draws", "losses", "score",
+	"rating_diff", "analysed", "accuracy", "trend",
+}
+
+func (s OpeningStats) csvRow() []string {
+	return []string{
+		s.Opening, s.Color, strconv.Itoa(s.Games),
+		strconv.Itoa(s.Wins), strconv.Itoa(s.Draws), strconv.Itoa(s.Losses),
+		strconv.FormatFloat(s.Score, 'f', 1, 64),
+		strconv.FormatFloat(s.RatingDiff, 'f', 1, 64),
+		strconv.Itoa(s.Analysed), strconv.FormatFloat(s.Accuracy, 'f', 1, 64),
+		strconv.FormatFloat(s.Trend, 'f', 1, 64),
+	}
+}
+
+// scorePercent returns the percentage of points the user scored in games.
+func scorePercent(games []Game, user string) float64 {
+	var s ResultStats
+	for _, g := range games {
+		s.add(g, user)
+	}
+	return s.Score()
+}
+
+// openingKey returns the group of a game: its ECO code, its opening name, or
+// its first moves when group is a number of moves.
+func openingKey(g *Game, group string) string {
+	switch group {
+	case "eco":
+		return g.Opening().ECO
+	case "", "name":
+		return g.Opening().String()
+	}
+
+	n, _ := strconv.Atoi(group)
+	parsedGame, err := g.Game()
+	if err != nil {
+		log.WithError(err).WithField("url", g.URL).Warn("Could not parse game")
+		return ""
+	}
+
+	nalg := chess.AlgebraicNotation{}
+	positions := parsedGame.Positions()
+	var moves []string
+	for i, m := range parsedGame.Moves() {
+		if i >= n*2 {
+			break
+		}
+		moves = append(moves, nalg.Encode(positions[i], m))
+	}
+	return strings.Join(moves, " ")
+}
+
+// openingStats groups games by opening and the user's color. Openings played
+// at least min times are returned, worst score first.
+func openingStats(games []Game, user string, group string, min int, analyses map[string]AnalysisRecord) []*OpeningStats {
+	groups := make(map[string]*OpeningStats)
+	for i := range games {
+		g := &games[i]
+
+		color, player, opponent := "white", g.White, g.Black
+		if g.Black.Username == user {
+			color, player, opponent = "black", g.Black, g.White
+		}
+
+		key := openingKey(g, group)
+		s, ok := groups[color+key]
+		if !ok {
+			s = &OpeningStats{Opening: key, Color: color}
+			groups[color+key] = s
+		}
+
+		s.add(*g, user)
+		s.RatingDiff += float64(player.Rating - opponent.Rating)
+
+		for _, summary := range analyses[g.ID()].Summary {
+			if summary.Color == color {
+				s.Analysed++
+				s.Accuracy += summary.Accuracy
+			}
+		}
+
+		s.games = append(s.games, *g)
+	}
+
+	var stats []*OpeningStats
+	for _, s := range groups {
+		if s.Games < min {
+			continue
+		}
+
+		s.Score = s.ResultStats.Score()
+		s.RatingDiff /= float64(s.Games)
+		if s.Analysed > 0 {
+			s.Accuracy /= float64(s.Analysed)
+		}
+		if s.Games >= 4 {
+			half := s.Games / 2
+			s.Trend = scorePercent(s.games[:half], user) - scorePercent(s.games[half:], user)
+		}
+		stats = append(stats, s)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Score != stats[j].Score {
+			return stats[i].Score < stats[j].Score
+		}
+		if stats[i].Games != stats[j].Games {
+			return stats[i].Games > stats[j].Games
+		}
+		if stats[i].Opening != stats[j].Opening {
+			return stats[i].Opening < stats[j].Opening
+		}
+		return stats[i].Color < stats[j].Color
+	})
+	return stats
+}
+
+// StatsOpenings reports how the user scores in each opening with each color.
+func StatsOpenings(cfg config) {
+	switch cfg.group {
+	case "", "eco", "name":
+	default:
+		if n, err := strconv.Atoi(cfg.group); err != nil || n < 1 {
+			log.WithField("group", cfg.group).Fatal("Unknown opening grouping")
+		}
+	}
+
+	games := matchingGames(cfg)
+	stats := openingStats(games, cfg.user, cfg.group, cfg.minGames, LoadAnalyses(cfg.user))
+
+	if isDataOutput(cfg.output) {
+		if err := writeRecords(os.Stdout, cfg.output, openingStatsCSVHeader, stats); err != nil {
+			log.WithError(err).Fatal("Could not write statistics")
+		}
+		return
+	}
+
+	fmt.Print(formatOpeningStats(stats))
+}
+
+// openingWidth is the width of the opening column in text output
+const openingWidth = 48
+
+func formatOpeningStats(stats []*OpeningStats) string {
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "%-*s %-5s %5s %5s %5s %5s %6s %5s %5s %5s\n",
+		openingWidth, "opening", "color", "games", "win", "draw", "loss",
+		"score", "diff", "acc", "trend")
+	for _, s := range stats {
+		// by runes, as padding is, so names aren't cut mid-character
+		name := s.Opening
+		if r := []rune(name); len(r) > openingWidth {
+			name = string(r[:openingWidth-3]) + "..."
+		}
+
+		accuracy := "-"
+		if s.Analysed > 0 {
+			accuracy = fmt.Sprintf("%.1f", s.Accuracy)
+		}
+		trend := "-"
+		if s.Games >= 4 {
+			trend = fmt.Sprintf("%+.0f", s.Trend)
+		}
+
+		fmt.Fprintf(buf, "%-*s %-5s %5d %5d %5d %5d %5.0f%% %+5.0f %5s %5s\n",
+			openingWidth, name, s.Color, s.Games, s.Wins, s.Draws, s.Losses,
+			s.Score, s.RatingDiff, accuracy, trend)
+	}
+	return buf.String()
+}
+
+// RatingRecord is the user's rating after a game, as written by stats in
+// json, jsonl and csv formats for plotting.
+type RatingRecord struct {
+	GameID      string    `json:"game_id"`
+	EndTime     time.Time `json:"end_time"`
+	TimeClass   string    `json:"time_class"`
+	TimeControl string    `json:"time_control"`
+	Rating      int       `json:"rating"`
+}
+
+var ratingCSVHeader = []string{"game_id", "end_time", "time_class", "time_control", "rating"}
+
+func (r RatingRecord) csvRow() []string {
+	return []string{
+		r.GameID, r.EndTime.Format(time.RFC3339), r.TimeClass, r.TimeControl,
+		strconv.Itoa(r.Rating),
+	}
+}
+
+// ResultStats counts the user's results in a set of games.
+type ResultStats struct {
+	Games  int `json:"games"`
+	Wins   int `json:"wins"`
+	Draws  int `json:"draws"`
+	Losses int `json:"losses"`
+	// sum of opponent ratings, for performance rating
+	opponentRatings int
+}
+
+func (s *ResultStats) add(g Game, user string) {
+	player, opponent := g.White, g.Black
+	if g.Black.Username == user {
+		player, opponent = g.Black, g.White
+	}
+
+	s.Games++
+	s.opponentRatings += opponent.Rating
+	switch player.NormalizedResult() {
+	case "win":
+		s.Wins++
+	case "draw":
+		s.Draws++
+	default:
+		s.Losses++
+	}
+}
+
+// Score returns the percentage of points scored.
+func (s ResultStats) Score() float64 {
+	if s.Games == 0 {
+		return 0
+	}
+	return (float64(s.Wins) + float64(s.Draws)/2) / float64(s.Games) * 100
+}
+
+// Performance returns the linear performance rating: the average opponent
+// rating, plus 400 for every win and minus 400 for every loss per game.
+func (s ResultStats) Performance() int {
+	if s.Games == 0 {
+		return 0
+	}
+	return (s.opponentRatings + 400*(s.Wins-s.Losses)) / s.Games
+}
+
+// sparkline draws values as a line of block characters, averaging them into
+// at most width buckets.
+func sparkline(values []int, width int) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	n := width
+	if len(values) < n {
+		n = len(values)
+	}
+
+	var buckets []float64
+	for i := 0; i < n; i++ {
+		from := i * len(values) / n
+		to := (i + 1) * len(values) / n
+		var sum float64
+		for _, v := range values[from:to] {
+			sum += float64(v)
+		}
+		buckets = append(buckets, sum/float64(to-from))
+	}
+
+	lo, hi := buckets[0], buckets[0]
+	for _, b := range buckets {
+		lo, hi = math.Min(lo, b), math.Max(hi, b)
+	}
+
+	blocks := []rune("▁▂▃▄▅▆▇█")
+	var line []rune
+	for _, b := range buckets {
+		i := len(blocks) / 2
+		if hi > lo {
+			i = int((b - lo) / (hi - lo) * float64(len(blocks)-1))
+		}
+		line = append(line, blocks[i])
+	}
+	return string(line)
+}
+
+// sparklineWidth is the most characters a rating sparkline takes
+const sparklineWidth = 60
+
+// formatRatingHistory describes the user's rating over games of one time
+// class, oldest first.
+func formatRatingHistory(class string, games []Game, user string) string {
+	var ratings []int
+	peak, trough := games[0], games[0]
+	for _, g := range games {
+		rating := userRating(g, user)
+		ratings = append(ratings, rating)
+		if rating > userRating(peak, user) {
+			peak = g
+		}
+		if rating < userRating(trough, user) {
+			trough = g
+		}
+	}
+
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "%s (%d games)\n", class, len(games))
+	fmt.Fprintf(buf, "rating  %s %d → %d\n",
+		sparkline(ratings, sparklineWidth), ratings[0], ratings[len(ratings)-1])
+	fmt.Fprintf(buf, "peak    %d on %s\n", userRating(peak, user), peak.EndTime.Format("2006-01-02"))
+	fmt.Fprintf(buf, "trough  %d on %s\n", userRating(trough, user), trough.EndTime.Format("2006-01-02"))
+	return buf.String()
+}
+
+func userRating(g Game, user string) int {
+	if g.Black.Username == user {
+		return g.Black.Rating
+	}
+	return g.White.Rating
+}
+
+// streaks returns the longest runs of wins and losses in games, oldest
+// first. Draws end both.
+func streaks(games []Game, user string) (wins, losses int) {
+	var w, l int
+	for _, g := range games {
+		player := g.White
+		if g.Black.Username == user {
+			player = g.Black
+		}
+
+		switch player.NormalizedResult() {
+		case "win":
+			w, l = w+1, 0
+		case "draw":
+			w, l = 0, 0
+		default:
+			w, l = 0, l+1
+		}
+		if w > wins {
+			wins = w
+		}
+		if l > losses {
+			losses = l
+		}
+	}
+	return wins, losses
+}
+
+func formatResultStats(buf io.Writer, name string, s ResultStats) {
+	fmt.Fprintf(buf, "%-12s %5d %5d %5d %5d %5.0f%% %5d\n",
+		name, s.Games, s.Wins, s.Draws, s.Losses, s.Score(), s.Performance())
+}
+
+// StatsRatings reports the user's rating history per time class and how
+// they score by color, month and opponent strength.
+func StatsRatings(cfg config) {
+	games := matchingGames(cfg)
+	if len(games) == 0 {
+		log.WithField("user", cfg.user).Fatal("No games for statistics")
+	}
+
+	// oldest first
+	for i, j := 0, len(games)-1; i < j; i, j = i+1, j-1 {
+		games[i], games[j] = games[j], games[i]
+	}
+
+	if isDataOutput(cfg.output) {
+		var records []RatingRecord
+		for _, g := range games {
+			records = append(records, RatingRecord{
+				GameID:      g.ID(),
+				EndTime:     g.EndTime.UTC(),
+				TimeClass:   g.TimeClass,
+				TimeControl: g.TimeControl.Code(),
+				Rating:      userRating(g, cfg.user),
+			})
+		}
+		if err := writeRecords(os.Stdout, cfg.output, ratingCSVHeader, records); err != nil {
+			log.WithError(err).Fatal("Could not write statistics")
+		}
+		return
+	}
+
+	var classes, months []string
+	byClass := make(map[string][]Game)
+	byMonth := make(map[string]*ResultStats)
+	var timeControls []TimeControl
+	byTimeControl := make(map[string]*ResultStats)
+	byTermination := make(map[Termination]*ResultStats)
+	var white, black, higher, equal, lower ResultStats
+	for _, g := range games {
+		if _, ok := byClass[g.TimeClass]; !ok {
+			classes = append(classes, g.TimeClass)
+		}
+		byClass[g.TimeClass] = append(byClass[g.TimeClass], g)
+
+		month := g.EndTime.Format("2006-01")
+		if _, ok := byMonth[month]; !ok {
+			months = append(months, month)
+			byMonth[month] = &ResultStats{}
+		}
+		byMonth[month].add(g, cfg.user)
+
+		tc := g.TimeControl.String()
+		if _, ok := byTimeControl[tc]; !ok {
+			timeControls = append(timeControls, g.TimeControl)
+			byTimeControl[tc] = &ResultStats{}
+		}
+		byTimeControl[tc].add(g, cfg.user)
+
+		if byTermination[g.Termination()] == nil {
+			byTermination[g.Termination()] = &ResultStats{}
+		}
+		byTermination[g.Termination()].add(g, cfg.user)
+
+		player, opponent := g.White, g.Black
+		if g.Black.Username == cfg.user {
+			player, opponent = g.Black, g.White
+			black.add(g, cfg.user)
+		} else {
+			white.add(g, cfg.user)
+		}
+
+		switch {
+		case opponent.Rating > player.Rating:
+			higher.add(g, cfg.user)
+		case opponent.Rating < player.Rating:
+			lower.add(g, cfg.user)
+		default:
+			equal.add(g, cfg.user)
+		}
+	}
+	sort.Strings(classes)
+	sortTimeControls(timeControls)
+
+	for _, class := range classes {
+		fmt.Println(formatRatingHistory(class, byClass[class], cfg.user))
+	}
+
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "%-12s %5s %5s %5s %5s %6s %5s\n",
+		"", "games", "win", "draw", "loss", "score", "perf")
+	formatResultStats(buf, "as white", white)
+	formatResultStats(buf, "as black", black)
+	formatResultStats(buf, "vs higher", higher)
+	if equal.Games > 0 {
+		formatResultStats(buf, "vs equal", equal)
+	}
+	formatResultStats(buf, "vs lower", lower)
+	fmt.Fprintln(buf)
+	for _, tc := range timeControls {
+		formatResultStats(buf, tc.String(), *byTimeControl[tc.String()])
+	}
+	fmt.Fprintln(buf)
+	for _, month := range months {
+		formatResultStats(buf, month, *byMonth[month])
+	}
+	fmt.Fprintln(buf)
+
+	fmt.Fprintf(buf, "%-32s %5s %5s %5s\n", "ended by", "win", "draw", "loss")
+	for t := UnknownTermination; t <= Lost; t++ {
+		s, ok := byTermination[t]
+		if !ok {
+			continue
+		}
+		reason := t.Reason()
+		if reason == "" {
+			reason = t.String()
+		}
+		fmt.Fprintf(buf, "%-32s %5d %5d %5d\n", reason, s.Wins, s.Draws, s.Losses)
+	}
+	fmt.Fprintln(buf)
+
+	wins, losses := streaks(games, cfg.user)
+	fmt.Fprintf(buf, "longest win streak   %d\n", wins)
+	fmt.Fprintf(buf, "longest loss streak  %d\n", losses)
+	fmt.Print(buf.String())
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/json"
 	"net/url"
+	"path"
+	"reflect"
 	"strings"
 	"time"
 
@@ -13,8 +15,15 @@ import (
 type Player struct {
 	Username string
 	Rating   int
-	Result   string
-	URL      *url.URL
+	// Chess.com result code, and the same as a typed termination
+	Result      string
+	Termination Termination
+	URL         *url.URL
+	UUID        string
+
+	// fields Chess.com sent that aren't known, kept so they aren't lost from
+	// the cache
+	extra map[string]json.RawMessage
 }
 
 type PlayerT struct {
@@ -22,6 +31,7 @@ type PlayerT struct {
 	Rating   int    `json:"rating"`
 	Result   string `json:"result"`
 	URL      string `json:"@id"`
+	UUID     string `json:"uuid,omitempty"`
 }
 
 func (p *Player) UnmarshalJSON(data []byte) error {
@@ -40,6 +50,17 @@ func (p *Player) UnmarshalJSON(data []byte) error {
 	p.Rating = t.Rating
 	p.Result = t.Result
 	p.URL = url
+	p.UUID = t.UUID
+
+	if p.extra, err = unknownFields(data, t); err != nil {
+		return err
+	}
+
+	p.Termination, err = ParseTermination(t.Result)
+	if err != nil {
+		log.WithError(err).WithField("username", t.Username).
+			Warn("Player has unknown result")
+	}
 	return nil
 }
 
@@ -54,22 +75,13 @@ func (p Player) MarshalJSON() ([]byte, error) {
 		Rating:   p.Rating,
 		Result:   p.Result,
 		URL:      url,
+		UUID:     p.UUID,
 	}
-	return json.Marshal(t)
+	return marshalWithFields(t, p.extra)
 }
 
 func (p Player) NormalizedResult() string {
-	switch p.Result {
-	case "win":
-		return "win"
-	case "abandoned":
-		return "abandoned"
-	case "agreed", "repetition", "stalemate", "insufficient", "50move",
-		"timevsinsufficient":
-		return "draw"
-	default:
-		return "lose"
-	}
+	return p.Termination.Outcome()
 }
 
 type Game struct {
@@ -81,19 +93,68 @@ type Game struct {
 	White     Player
 	Black     Player
 
+	TimeControl TimeControl
+	// only set for daily games
+	StartTime time.Time
+	// final position, and the starting position
+	FEN          string
+	InitialSetup string
+	// moves in Chess.com's compact encoding
+	TCN  string
+	UUID string
+	// Chess.com's own accuracies, only set for games reviewed there
+	Accuracies *Accuracies
+	// URLs of the tournament or team match the game was played in, and of
+	// the opening on Chess.com
+	Tournament string
+	Match      string
+	ECOURL     string
+
 	pgn  string
 	game *chess.Game
+
+	// fields Chess.com sent that aren't known, kept so they aren't lost from
+	// the cache
+	extra map[string]json.RawMessage
+}
+
+type Accuracies struct {
+	White float64 `json:"white"`
+	Black float64 `json:"black"`
 }
 
 type GameT struct {
-	URL       string `json:"url"`
-	PGN       string `json:"pgn"`
-	EndTime   int64  `json:"end_time"`
-	Rated     bool   `json:"rated"`
-	TimeClass string `json:"time_class"`
-	Rules     string `json:"rules"`
-	White     Player `json:"white"`
-	Black     Player `json:"black"`
+	URL          string      `json:"url"`
+	PGN          string      `json:"pgn"`
+	EndTime      int64       `json:"end_time"`
+	Rated        bool        `json:"rated"`
+	TimeClass    string      `json:"time_class"`
+	Rules        string      `json:"rules"`
+	White        Player      `json:"white"`
+	Black        Player      `json:"black"`
+	TimeControl  string      `json:"time_control,omitempty"`
+	StartTime    int64       `json:"start_time,omitempty"`
+	FEN          string      `json:"fen,omitempty"`
+	InitialSetup string      `json:"initial_setup,omitempty"`
+	TCN          string      `json:"tcn,omitempty"`
+	UUID         string      `json:"uuid,omitempty"`
+	Accuracies   *Accuracies `json:"accuracies,omitempty"`
+	Tournament   string      `json:"tournament,omitempty"`
+	Match        string      `json:"match,omitempty"`
+	ECOURL       string      `json:"eco,omitempty"`
+}
+
+// ID returns the Chess.com ID of the game, which is the end of its URL.
+func (g Game) ID() string {
+	if g.URL == nil {
+		return ""
+	}
+	return path.Base(g.URL.Path)
+}
+
+// PGN returns the game as originally provided by Chess.com.
+func (g Game) PGN() string {
+	return g.pgn
 }
 
 func (g *Game) Game() (*chess.Game, error) {
@@ -129,8 +190,28 @@ func (g *Game) UnmarshalJSON(data []byte) error {
 	g.Rules = t.Rules
 	g.White = t.White
 	g.Black = t.Black
+	if t.TimeControl != "" {
+		g.TimeControl, err = ParseTimeControl(t.TimeControl)
+		if err != nil {
+			log.WithError(err).WithField("url", t.URL).
+				Warn("Game has invalid time control")
+		}
+	}
+	if t.StartTime != 0 {
+		g.StartTime = time.Unix(t.StartTime, 0)
+	}
+	g.FEN = t.FEN
+	g.InitialSetup = t.InitialSetup
+	g.TCN = t.TCN
+	g.UUID = t.UUID
+	g.Accuracies = t.Accuracies
+	g.Tournament = t.Tournament
+	g.Match = t.Match
+	g.ECOURL = t.ECOURL
 	g.pgn = t.PGN
-	return nil
+
+	g.extra, err = unknownFields(data, t)
+	return err
 }
 
 func (g Game) MarshalJSON() ([]byte, error) {
@@ -148,6 +229,59 @@ func (g Game) MarshalJSON() ([]byte, error) {
 		Rules:     g.Rules,
 		White:     g.White,
 		Black:     g.Black,
+
+		TimeControl:  g.TimeControl.Code(),
+		FEN:          g.FEN,
+		InitialSetup: g.InitialSetup,
+		TCN:          g.TCN,
+		UUID:         g.UUID,
+		Accuracies:   g.Accuracies,
+		Tournament:   g.Tournament,
+		Match:        g.Match,
+		ECOURL:       g.ECOURL,
+	}
+	if !g.StartTime.IsZero() {
+		t.StartTime = g.StartTime.Unix()
+	}
+	return marshalWithFields(t, g.extra)
+}
+
+// unknownFields returns the fields of a JSON object that aren't in known, a
+// struct with json tags that it was also decoded into.
+func unknownFields(data []byte, known interface{}) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	typ := reflect.TypeOf(known)
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		delete(fields, name)
+	}
+
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields, nil
+}
+
+// marshalWithFields encodes v, a struct, as a JSON object along with extra
+// fields that aren't part of it.
+func marshalWithFields(v interface{}, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for k, v := range extra {
+		if _, ok := fields[k]; !ok {
+			fields[k] = v
+		}
 	}
-	return json.Marshal(t)
+	return json.Marshal(fields)
 }
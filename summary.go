@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+const (
+	// centipawn loss of a single move is capped so one blunder into a
+	// forced mate doesn't dominate the average
+	maxCentipawnLoss = 1000
+)
+
+// Summary is the overall quality of one player's moves in an analysed game.
+type Summary struct {
+	Player string
+	Color  chess.Color
+
+	Moves        int
+	ACPL         float64
+	Accuracy     float64
+	BestMoves    int
+	Inaccuracies int
+	Mistakes     int
+	Blunders     int
+
+	// index into the game's plies of the move that gave away the most
+	// expected points, or -1 if there were no moves
+	BiggestSwing int
+	swing        float64
+
+	// indexed by phase
+	Phases [EndgamePhase + 1]PhaseSummary
+}
+
+// PhaseSummary is the quality of one player's moves in one phase of a game.
+type PhaseSummary struct {
+	Moves    int
+	ACPL     float64
+	Mistakes int
+	Blunders int
+}
+
+// CentipawnLoss returns how many centipawns the player making the move gave
+// away according to the engine.
+func (p Ply) CentipawnLoss() float64 {
+	return centipawnLoss(p.Before.Score, p.After.Score, p.Position.Turn() == chess.Black)
+}
+
+// centipawnLoss returns the centipawns given away by a move given white's
+// evaluation before and after it.
+func centipawnLoss(before, after float64, black bool) float64 {
+	loss := (before - after) * 100
+	if black {
+		loss *= -1
+	}
+	return math.Min(math.Max(loss, 0), maxCentipawnLoss)
+}
+
+// Accuracy returns the accuracy of the move as a percentage, using Lichess'
+// formula based on the expected score lost.
+func (p Ply) Accuracy() float64 {
+	acc := 103.1668*math.Exp(-0.04354*math.Max(p.Lost, 0)*100) - 3.1669
+	return math.Min(math.Max(acc, 0), 100)
+}
+
+// summarize returns summaries for white and black, in that order.
+func summarize(data Game, plies []Ply) [2]Summary {
+	summaries := [2]Summary{
+		{Player: data.White.Username, Color: chess.White, BiggestSwing: -1},
+		{Player: data.Black.Username, Color: chess.Black, BiggestSwing: -1},
+	}
+
+	for i, p := range plies {
+		// moves the engine couldn't judge would count as perfect
+		if p.Class == Unclassified {
+			continue
+		}
+
+		s := &summaries[0]
+		if p.Position.Turn() == chess.Black {
+			s = &summaries[1]
+		}
+
+		s.Moves++
+		s.ACPL += p.CentipawnLoss()
+		s.Accuracy += p.Accuracy()
+
+		ps := &s.Phases[p.Phase]
+		ps.Moves++
+		ps.ACPL += p.CentipawnLoss()
+
+		switch p.Class {
+		case Best:
+			s.BestMoves++
+		case Inaccuracy:
+			s.Inaccuracies++
+		case Mistake:
+			s.Mistakes++
+			ps.Mistakes++
+		case Blunder:
+			s.Blunders++
+			ps.Blunders++
+		}
+
+		if s.BiggestSwing < 0 || p.Lost > s.swing {
+			s.BiggestSwing = i
+			s.swing = p.Lost
+		}
+	}
+
+	for i := range summaries {
+		if n := summaries[i].Moves; n > 0 {
+			summaries[i].ACPL /= float64(n)
+			summaries[i].Accuracy /= float64(n)
+		}
+		for j := range summaries[i].Phases {
+			if n := summaries[i].Phases[j].Moves; n > 0 {
+				summaries[i].Phases[j].ACPL /= float64(n)
+			}
+		}
+	}
+
+	return summaries
+}
+
+// BestMoveRate returns the proportion of moves that matched the engine.
+func (s Summary) BestMoveRate() float64 {
+	if s.Moves == 0 {
+		return 0
+	}
+	return float64(s.BestMoves) / float64(s.Moves)
+}
+
+// formatSummaries lays out the summaries of both players side by side.
+func formatSummaries(summaries [2]Summary, plies []Ply) string {
+	w, b := summaries[0], summaries[1]
+
+	swing := func(s Summary) string {
+		if s.BiggestSwing < 0 {
+			return "-"
+		}
+		p := plies[s.BiggestSwing]
+		return fmt.Sprintf("%s %s%s (%+.0f%%)",
+			moveNumber(p.Position), p.SAN, p.Class.NAG(), -p.Lost*100)
+	}
+
+	buf := &strings.Builder{}
+	row := func(label, white, black string) {
+		fmt.Fprintf(buf, "%-14s %-24s %s\n", label, white, black)
+	}
+	row("", fmt.Sprintf("White (%s)", w.Player), fmt.Sprintf("Black (%s)", b.Player))
+	row("Accuracy", fmt.Sprintf("%.1f%%", w.Accuracy), fmt.Sprintf("%.1f%%", b.Accuracy))
+	row("ACPL", fmt.Sprintf("%.0f", w.ACPL), fmt.Sprintf("%.0f", b.ACPL))
+	row("Best moves",
+		fmt.Sprintf("%d/%d (%.0f%%)", w.BestMoves, w.Moves, w.BestMoveRate()*100),
+		fmt.Sprintf("%d/%d (%.0f%%)", b.BestMoves, b.Moves, b.BestMoveRate()*100))
+	row("Inaccuracies", fmt.Sprint(w.Inaccuracies), fmt.Sprint(b.Inaccuracies))
+	row("Mistakes", fmt.Sprint(w.Mistakes), fmt.Sprint(b.Mistakes))
+	row("Blunders", fmt.Sprint(w.Blunders), fmt.Sprint(b.Blunders))
+	row("Biggest swing", swing(w), swing(b))
+
+	phase := func(s PhaseSummary) string {
+		if s.Moves == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("ACPL %.0f, %d?, %d??", s.ACPL, s.Mistakes, s.Blunders)
+	}
+	for _, p := range phases {
+		if w.Phases[p].Moves+b.Phases[p].Moves > 0 {
+			name := p.String()
+			row(strings.ToUpper(name[:1])+name[1:], phase(w.Phases[p]), phase(b.Phases[p]))
+		}
+	}
+	return buf.String()
+}
+
+func colorName(c chess.Color) string {
+	if c == chess.Black {
+		return "Black"
+	}
+	return "White"
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Termination is a Chess.com result code: how a game ended for one player.
+type Termination int
+
+const (
+	UnknownTermination Termination = iota
+	Won
+	Checkmated
+	Resigned
+	Timeout
+	TimeVsInsufficient
+	Repetition
+	Agreed
+	Stalemate
+	FiftyMove
+	Insufficient
+	Abandoned
+	KingOfTheHill
+	ThreeCheck
+	BughousePartnerLose
+	// lost a variant game for another reason
+	Lost
+)
+
+// terminationCodes are the Chess.com result codes of each termination.
+var terminationCodes = map[Termination]string{
+	Won:                 "win",
+	Checkmated:          "checkmated",
+	Resigned:            "resigned",
+	Timeout:             "timeout",
+	TimeVsInsufficient:  "timevsinsufficient",
+	Repetition:          "repetition",
+	Agreed:              "agreed",
+	Stalemate:           "stalemate",
+	FiftyMove:           "50move",
+	Insufficient:        "insufficient",
+	Abandoned:           "abandoned",
+	KingOfTheHill:       "kingofthehill",
+	ThreeCheck:          "threecheck",
+	BughousePartnerLose: "bughousepartnerlose",
+	Lost:                "lose",
+}
+
+// terminationReasons describe how a game ended, as in "lost by resignation".
+var terminationReasons = map[Termination]string{
+	Checkmated:          "checkmate",
+	Resigned:            "resignation",
+	Timeout:             "timeout",
+	TimeVsInsufficient:  "timeout vs insufficient material",
+	Repetition:          "repetition",
+	Agreed:              "agreement",
+	Stalemate:           "stalemate",
+	FiftyMove:           "50-move rule",
+	Insufficient:        "insufficient material",
+	Abandoned:           "abandonment",
+	KingOfTheHill:       "king of the hill",
+	ThreeCheck:          "three-check",
+	BughousePartnerLose: "bughouse partner",
+}
+
+// terminationAliases maps friendlier names to Chess.com result codes.
+var terminationAliases = map[string]string{
+	"checkmate": "checkmated",
+	"mate":      "checkmated",
+	"resign":    "resigned",
+	"time":      "timeout",
+	"abandon":   "abandoned",
+	"agreement": "agreed",
+}
+
+// ParseTermination reads a Chess.com result code, or one of the aliases
+// accepted by -term.
+func ParseTermination(code string) (Termination, error) {
+	code = strings.ToLower(code)
+	if alias, ok := terminationAliases[code]; ok {
+		code = alias
+	}
+
+	for t, c := range terminationCodes {
+		if c == code {
+			return t, nil
+		}
+	}
+	return UnknownTermination, fmt.Errorf("Unknown result code %s", code)
+}
+
+func (t Termination) String() string {
+	if code, ok := terminationCodes[t]; ok {
+		return code
+	}
+	return "unknown"
+}
+
+// Reason describes how the game ended, or is empty for wins and unknown
+// codes, where the opponent's termination says how.
+func (t Termination) Reason() string {
+	return terminationReasons[t]
+}
+
+// Outcome collapses the termination into win, draw, lose or abandoned.
+func (t Termination) Outcome() string {
+	switch t {
+	case Won:
+		return "win"
+	case Abandoned:
+		return "abandoned"
+	case Agreed, Repetition, Stalemate, Insufficient, FiftyMove, TimeVsInsufficient:
+		return "draw"
+	default:
+		return "lose"
+	}
+}
+
+// Termination returns how the game ended: the code of the player who didn't
+// win, which is the same for both players in a draw.
+func (g Game) Termination() Termination {
+	if g.White.Termination == Won {
+		return g.Black.Termination
+	}
+	return g.White.Termination
+}
+
+// Describe says how the game ended for the user, e.g. "won by checkmate" or
+// "drew by repetition".
+func (g Game) Describe(user string) string {
+	player := g.White
+	if g.Black.Username == user {
+		player = g.Black
+	}
+
+	var verb string
+	switch player.Termination.Outcome() {
+	case "win":
+		verb = "won"
+	case "draw":
+		verb = "drew"
+	default:
+		verb = "lost"
+	}
+
+	if reason := g.Termination().Reason(); reason != "" {
+		return verb + " by " + reason
+	}
+	return verb
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeControl is a Chess.com time control: a starting time and increment
+// such as "180+2", or the time allowed per move in daily games such as
+// "1/259200".
+type TimeControl struct {
+	Base      time.Duration
+	Increment time.Duration
+	// time per move in daily games, zero otherwise
+	PerMove time.Duration
+
+	// the time control as Chess.com wrote it
+	code string
+}
+
+// ParseTimeControl reads a time control in Chess.com's format. Controls that
+// can't be read are kept as they are, but have no times.
+func ParseTimeControl(code string) (TimeControl, error) {
+	tc := TimeControl{code: code}
+
+	if i := strings.Index(code, "/"); i >= 0 {
+		seconds, err := strconv.Atoi(code[i+1:])
+		if err != nil || code[:i] != "1" {
+			return tc, fmt.Errorf("Invalid daily time control %s", code)
+		}
+		tc.PerMove = time.Duration(seconds) * time.Second
+		return tc, nil
+	}
+
+	parts := strings.SplitN(code, "+", 2)
+	seconds, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return tc, fmt.Errorf("Invalid time control %s", code)
+	}
+	tc.Base = time.Duration(seconds) * time.Second
+
+	if len(parts) == 2 {
+		inc, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return tc, fmt.Errorf("Invalid increment in time control %s", code)
+		}
+		tc.Increment = time.Duration(inc) * time.Second
+	}
+	return tc, nil
+}
+
+// Daily returns whether moves are timed in days rather than the game on a
+// clock.
+func (tc TimeControl) Daily() bool {
+	return tc.PerMove > 0
+}
+
+// Timed returns whether the game was played on a clock.
+func (tc TimeControl) Timed() bool {
+	return tc.Base > 0
+}
+
+// Code returns the time control in Chess.com's format.
+func (tc TimeControl) Code() string {
+	return tc.code
+}
+
+// String returns the time control as players write it, e.g. "3+2" for three
+// minutes with a two second increment, or "3 days" per move.
+func (tc TimeControl) String() string {
+	switch {
+	case tc.Daily():
+		days := tc.PerMove / (24 * time.Hour)
+		if days == 1 {
+			return "1 day"
+		}
+		return fmt.Sprintf("%d days", days)
+	case tc.Timed():
+		return strconv.FormatFloat(tc.Base.Minutes(), 'f', -1, 64) + "+" +
+			strconv.Itoa(int(tc.Increment/time.Second))
+	}
+	return tc.code
+}
+
+// Matches returns whether s names the time control, either as players write
+// it or in Chess.com's format.
+func (tc TimeControl) Matches(s string) bool {
+	return s == tc.String() || s == tc.code
+}
+
+// matchTimeControl returns whether the time control matches any of those
+// given.
+func matchTimeControl(tc TimeControl, controls []string) bool {
+	for _, c := range controls {
+		if tc.Matches(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortTimeControls orders time controls from fastest to slowest: by starting
+// time, then increment, with daily games last.
+func sortTimeControls(controls []TimeControl) {
+	sort.Slice(controls, func(i, j int) bool {
+		a, b := controls[i], controls[j]
+		switch {
+		case a.PerMove != b.PerMove:
+			return a.PerMove < b.PerMove
+		case a.Base != b.Base:
+			return a.Base < b.Base
+		case a.Increment != b.Increment:
+			return a.Increment < b.Increment
+		}
+		return a.code < b.code
+	})
+}
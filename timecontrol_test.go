@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeControl(t *testing.T) {
+	tests := []struct {
+		code      string
+		base      time.Duration
+		increment time.Duration
+		perMove   time.Duration
+		str       string
+		err       bool
+	}{
+		{code: "180+2", base: 3 * time.Minute, increment: 2 * time.Second, str: "3+2"},
+		{code: "600", base: 10 * time.Minute, str: "10+0"},
+		{code: "30", base: 30 * time.Second, str: "0.5+0"},
+		{code: "90+1", base: 90 * time.Second, increment: time.Second, str: "1.5+1"},
+		{code: "1/86400", perMove: 24 * time.Hour, str: "1 day"},
+		{code: "1/259200", perMove: 72 * time.Hour, str: "3 days"},
+		{code: "", str: "", err: true},
+		{code: "-", str: "-", err: true},
+		{code: "180+x", base: 3 * time.Minute, str: "3+0", err: true},
+		{code: "2/86400", str: "2/86400", err: true},
+		{code: "1/x", str: "1/x", err: true},
+	}
+
+	for _, tt := range tests {
+		tc, err := ParseTimeControl(tt.code)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseTimeControl(%q) error = %v, want error %v", tt.code, err, tt.err)
+		}
+		if tc.Base != tt.base || tc.Increment != tt.increment || tc.PerMove != tt.perMove {
+			t.Errorf("ParseTimeControl(%q) = %v+%v per move %v, want %v+%v per move %v",
+				tt.code, tc.Base, tc.Increment, tc.PerMove, tt.base, tt.increment, tt.perMove)
+		}
+		if got := tc.String(); got != tt.str {
+			t.Errorf("ParseTimeControl(%q).String() = %q, want %q", tt.code, got, tt.str)
+		}
+		if got := tc.Code(); got != tt.code {
+			t.Errorf("ParseTimeControl(%q).Code() = %q", tt.code, got)
+		}
+	}
+}
+
+func TestTimeControlMatches(t *testing.T) {
+	tests := []struct {
+		code  string
+		s     string
+		match bool
+	}{
+		{"180+2", "3+2", true},
+		{"180+2", "180+2", true},
+		{"180+2", "3+0", false},
+		{"180", "3+0", true},
+		{"180", "3", false},
+		{"1/259200", "3 days", true},
+		{"1/259200", "1/259200", true},
+		{"1/259200", "1 day", false},
+	}
+
+	for _, tt := range tests {
+		tc, _ := ParseTimeControl(tt.code)
+		if got := tc.Matches(tt.s); got != tt.match {
+			t.Errorf("%s.Matches(%q) = %v, want %v", tt.code, tt.s, got, tt.match)
+		}
+	}
+}
+
+func TestSortTimeControls(t *testing.T) {
+	var controls []TimeControl
+	for _, code := range []string{"1/86400", "600", "180+2", "60", "180", "1/259200"} {
+		tc, _ := ParseTimeControl(code)
+		controls = append(controls, tc)
+	}
+
+	sortTimeControls(controls)
+
+	want := []string{"60", "180", "180+2", "600", "1/86400", "1/259200"}
+	for i, tc := range controls {
+		if tc.Code() != want[i] {
+			t.Errorf("sorted[%d] = %s, want %s", i, tc.Code(), want[i])
+		}
+	}
+}
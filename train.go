@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/notnil/chess"
+)
+
+// SM-2 parameters.
+const (
+	initialEase = 2.5
+	minEase     = 1.3
+	// lowest quality that counts as remembering the puzzle
+	passingQuality = 3
+)
+
+// Review is the spaced repetition schedule of a puzzle, following SM-2.
+type Review struct {
+	// reviews passed in a row
+	Repetitions int `json:"repetitions"`
+	// days between the last review and the next
+	Interval int       `json:"interval"`
+	Ease     float64   `json:"ease"`
+	Due      time.Time `json:"due"`
+	Reviewed time.Time `json:"reviewed"`
+}
+
+// grade schedules the next review given the quality of the answer, from 0
+// for no idea to 5 for a perfect answer.
+func (r *Review) grade(quality int, now time.Time) {
+	if r.Ease == 0 {
+		r.Ease = initialEase
+	}
+
+	if quality < passingQuality {
+		r.Repetitions = 0
+		r.Interval = 1
+	} else {
+		switch r.Repetitions {
+		case 0:
+			r.Interval = 1
+		case 1:
+			r.Interval = 6
+		default:
+			r.Interval = int(math.Round(float64(r.Interval) * r.Ease))
+		}
+		r.Repetitions++
+	}
+
+	q := float64(5 - quality)
+	r.Ease = math.Max(r.Ease+0.1-q*(0.08+q*0.02), minEase)
+	r.Reviewed = now
+	r.Due = now.AddDate(0, 0, r.Interval)
+}
+
+// trainingQueue returns the puzzles to review: those due, most overdue
+// first, then ones never seen, newest first, up to limit.
+func trainingQueue(puzzles []Puzzle, reviews map[string]Review, now time.Time, limit int) []Puzzle {
+	var due, unseen []Puzzle
+	for _, p := range puzzles {
+		r, ok := reviews[p.ID]
+		switch {
+		case !ok:
+			unseen = append(unseen, p)
+		case !r.Due.After(now):
+			due = append(due, p)
+		}
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return reviews[due[i].ID].Due.Before(reviews[due[j].ID].Due)
+	})
+	sort.SliceStable(unseen, func(i, j int) bool {
+		return unseen[i].Date.After(unseen[j].Date)
+	})
+
+	queue := append(due, unseen...)
+	if len(queue) > limit {
+		queue = queue[:limit]
+	}
+	return queue
+}
+
+// trainPuzzle asks for each of the user's moves in the puzzle's solution,
+// playing the opponent's replies, and returns the quality of the answer for
+// SM-2: 5 if solved, 2 if only the first move was found, or 1 if not, and 0
+// if the user gave up. Only solving the whole puzzle counts as a pass. quit
+// is set when the user asks to stop.
+func trainPuzzle(in *bufio.Scanner, p Puzzle) (quality int, quit bool) {
+	pos, err := p.Position()
+	if err != nil {
+		log.WithError(err).WithField("id", p.ID).Warn("Could not read puzzle")
+		return 0, false
+	}
+
+	start := pos
+	reveal := func(from int) {
+		fmt.Printf("The solution was %s\n", variationString(pos, p.SolutionSAN[from:]))
+	}
+
+	for i := 0; i < len(p.Solution); i += 2 {
+		fmt.Println(strings.TrimPrefix(pos.Board().Draw(), "\n"))
+		fmt.Printf("%s to move\n", colorName(pos.Turn()))
+
+		var m *chess.Move
+		for m == nil {
+			fmt.Print("move, give up or quit> ")
+			if !in.Scan() {
+				fmt.Println()
+				return 0, true
+			}
+
+			input := strings.TrimSpace(in.Text())
+			switch input {
+			case "quit", "q":
+				return 0, true
+			case "", "give up", "?":
+				reveal(i)
+				return 0, false
+			}
+
+			if m, err = decodeMove(pos, input); err != nil {
+				fmt.Printf("Invalid move %s\n", input)
+				m = nil
+			}
+		}
+
+		next := pos.Update(m)
+		if m.String() != p.Solution[i] && next.Status() != chess.Checkmate {
+			fmt.Printf("%s is not it. ", chess.AlgebraicNotation{}.Encode(pos, m))
+			reveal(i)
+			if i == 0 {
+				return 1, false
+			}
+			return 2, false
+		}
+
+		fmt.Printf("Correct, %s\n", variationString(pos, []string{p.SolutionSAN[i]}))
+		if next.Status() == chess.Checkmate || i+1 >= len(p.Solution) {
+			break
+		}
+
+		reply, err := decodeMove(next, p.Solution[i+1])
+		if err != nil {
+			log.WithError(err).WithField("id", p.ID).Warn("Invalid move in puzzle solution")
+			return 5, false
+		}
+		fmt.Printf("Opponent plays %s\n\n", variationString(next, []string{p.SolutionSAN[i+1]}))
+		pos = next.Update(reply)
+	}
+
+	if len(p.Solution) > 1 {
+		fmt.Printf("Engine line %s\n", variationString(start, p.SolutionSAN))
+	}
+	return 5, false
+}
+
+// Train drills the puzzles made by the puzzles command from games matching
+// the filters, scheduling each with SM-2 so ones that are missed come back
+// sooner. Up to the limit of puzzles are reviewed per session.
+func Train(cfg config) {
+	matching := make(map[string]bool)
+	for _, g := range matchingGames(cfg) {
+		matching[g.ID()] = true
+	}
+
+	var puzzles []Puzzle
+	for _, p := range LoadPuzzles(cfg.user) {
+		if matching[p.GameID] && len(p.Solution) > 0 {
+			puzzles = append(puzzles, p)
+		}
+	}
+	if len(puzzles) == 0 {
+		log.WithField("user", cfg.user).Fatal("No puzzles, make some with the puzzles command")
+	}
+
+	reviews := LoadTraining(cfg.user)
+	queue := trainingQueue(puzzles, reviews, time.Now(), cfg.limit)
+	if len(queue) == 0 {
+		next := time.Time{}
+		for _, p := range puzzles {
+			if due := reviews[p.ID].Due; next.IsZero() || due.Before(next) {
+				next = due
+			}
+		}
+		fmt.Printf("No puzzles due, next on %s\n", next.Format("2006-01-02"))
+		return
+	}
+
+	in := bufio.NewScanner(os.Stdin)
+	var solved, reviewed int
+	for i, p := range queue {
+		fmt.Printf("Puzzle %d of %d against %s, %s\n",
+			i+1, len(queue), opponentName(p, cfg.user), p.URL)
+		fmt.Printf("Find better than %s %s, a %s\n\n", p.MoveNumber, p.Played, p.Classification)
+
+		quality, quit := trainPuzzle(in, p)
+		if quit {
+			break
+		}
+
+		r := reviews[p.ID]
+		r.grade(quality, time.Now())
+		reviews[p.ID] = r
+		SaveTraining(cfg.user, reviews)
+
+		reviewed++
+		if quality >= passingQuality {
+			solved++
+		}
+		if r.Interval == 1 {
+			fmt.Print("Next review tomorrow\n\n")
+		} else {
+			fmt.Printf("Next review in %d days\n\n", r.Interval)
+		}
+	}
+
+	fmt.Printf("Solved %d of %d\n", solved, reviewed)
+}
+
+// opponentName returns who the user was playing in the puzzle's game.
+func opponentName(p Puzzle, user string) string {
+	if p.White == user {
+		return p.Black
+	}
+	return p.White
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestReviewGrade(t *testing.T) {
+	tests := []struct {
+		name      string
+		qualities []int
+		reps      int
+		interval  int
+		ease      float64
+	}{
+		{"first pass", []int{5}, 1, 1, 2.6},
+		{"second pass", []int{5, 5}, 2, 6, 2.7},
+		{"third pass", []int{5, 5, 5}, 3, 16, 2.8},
+		{"hesitant passes", []int{3, 3, 3}, 3, 13, 2.08},
+		{"fail resets", []int{5, 5, 1}, 0, 1, 2.16},
+		{"pass after fail", []int{5, 5, 1, 5}, 1, 1, 2.26},
+		{"partly solved", []int{5, 2}, 0, 1, 2.28},
+		{"ease floor", []int{0, 0, 0, 0, 0}, 0, 1, minEase},
+	}
+
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		var r Review
+		for _, q := range tt.qualities {
+			r.grade(q, now)
+		}
+
+		if r.Repetitions != tt.reps || r.Interval != tt.interval ||
+			math.Abs(r.Ease-tt.ease) > 1e-9 {
+			t.Errorf("%s: got %d repetitions, %d days, ease %.2f, want %d, %d, %.2f",
+				tt.name, r.Repetitions, r.Interval, r.Ease, tt.reps, tt.interval, tt.ease)
+		}
+		if want := now.AddDate(0, 0, tt.interval); !r.Due.Equal(want) {
+			t.Errorf("%s: due %v, want %v", tt.name, r.Due, want)
+		}
+		if !r.Reviewed.Equal(now) {
+			t.Errorf("%s: reviewed %v, want %v", tt.name, r.Reviewed, now)
+		}
+	}
+}
+
+func TestTrainingQueue(t *testing.T) {
+	now := time.Date(2021, 5, 10, 0, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time {
+		return time.Date(2021, 5, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	puzzles := []Puzzle{
+		{ID: "old-unseen", Date: day(1)},
+		{ID: "due-today", Date: day(2)},
+		{ID: "not-due", Date: day(3)},
+		{ID: "new-unseen", Date: day(4)},
+		{ID: "overdue", Date: day(5)},
+	}
+	reviews := map[string]Review{
+		"due-today": {Due: now},
+		"not-due":   {Due: day(12)},
+		"overdue":   {Due: day(8)},
+	}
+
+	tests := []struct {
+		limit int
+		want  []string
+	}{
+		{10, []string{"overdue", "due-today", "new-unseen", "old-unseen"}},
+		{3, []string{"overdue", "due-today", "new-unseen"}},
+		{1, []string{"overdue"}},
+	}
+
+	for _, tt := range tests {
+		queue := trainingQueue(puzzles, reviews, now, tt.limit)
+		var got []string
+		for _, p := range queue {
+			got = append(got, p.ID)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("limit %d: got %v, want %v", tt.limit, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("limit %d: got %v, want %v", tt.limit, got, tt.want)
+				break
+			}
+		}
+	}
+}